@@ -39,16 +39,24 @@ overseer_host = config.get(config_section, 'overseer_host')
 overseer_port = config.getint(config_section, 'overseer_port')
 
 
-def logout():
+def run_as_active_user(command):
     session_id = win32ts.WTSGetActiveConsoleSessionId()
     user_token = win32ts.WTSQueryUserToken(session_id)
     startup = win32process.STARTUPINFO()
     priority = win32con.NORMAL_PRIORITY_CLASS
     environment = win32profile.CreateEnvironmentBlock(user_token, False)
-    win32process.CreateProcessAsUser(user_token, None, 'shutdown -l',
+    win32process.CreateProcessAsUser(user_token, None, command,
                                      None, None, True, priority, environment, None, startup)
 
 
+def logout():
+    run_as_active_user('shutdown -l')
+
+
+def lock():
+    run_as_active_user('rundll32.exe user32.dll,LockWorkStation')
+
+
 def overseer_pinger(overseer_ip):
     while True:
         s = socket.socket()
@@ -111,6 +119,15 @@ class AgentService(win32serviceutil.ServiceFramework):
 
             return ''
 
+        @app.route('/lock', methods=['POST'])
+        def post_lock():
+            if overseer_ip != flask.request.remote_addr:
+                return ''
+
+            lock()
+
+            return ''
+
         @app.route('/status', methods=['GET'])
         def get_status():
             if overseer_ip != flask.request.remote_addr:
@@ -137,6 +154,38 @@ class AgentService(win32serviceutil.ServiceFramework):
 
             return flask.Response(frame_jpg.tobytes(), 200, {'X-Active-User': user_name_encoded})
 
+        @app.route('/frames', methods=['GET'])
+        def get_frames():
+            if overseer_ip != flask.request.remote_addr:
+                return ''
+
+            count = flask.request.args.get('count', 5, type=int)
+            interval = flask.request.args.get('interval', 200, type=int)
+
+            vc = cv2.VideoCapture(camera_id, cv2.CAP_DSHOW)
+            for i in range(20):
+                read, frame = vc.read()
+
+            frames = []
+            for i in range(count):
+                if i > 0:
+                    time.sleep(interval / 1000)
+
+                read, frame = vc.read()
+                if not read:
+                    vc.release()
+                    return flask.Response('unable to read frame', 500)
+
+                encoded, frame_jpg = cv2.imencode('.jpg', frame)
+                if not encoded:
+                    vc.release()
+                    return flask.Response('unable to JPEG encode frame', 500)
+
+                frames.append(base64.b64encode(frame_jpg.tobytes()).decode('ascii'))
+            vc.release()
+
+            return flask.jsonify({'frames': frames})
+
         app.run(agent_host, agent_port)
 
     def SvcStop(self):
@@ -0,0 +1,246 @@
+// Package enrollment keeps photos automatically collected for enrollment
+// from confirmed matches. Every photo is stored in directory along with its
+// JSON record, so photos can be reviewed before they are added to the user's
+// gallery and rolled back after.
+package enrollment
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dimuls/oko/face"
+)
+
+type Status string
+
+const (
+	StatusPending    Status = "pending"
+	StatusAdded      Status = "added"
+	StatusRejected   Status = "rejected"
+	StatusRolledBack Status = "rolled_back"
+)
+
+const (
+	recordFileExt = ".json"
+	photoFileExt  = ".jpg"
+)
+
+var ErrRecordNotFound = errors.New("record not found")
+
+type Record struct {
+	ID        string             `json:"id"`
+	HostName  string             `json:"host_name"`
+	UserName  string             `json:"user_name"`
+	UserID    string             `json:"user_id"`
+	Score     float64            `json:"score"`
+	Quality   face.QualityReport `json:"quality"`
+	Status    Status             `json:"status"`
+	PhotoID   string             `json:"photo_id,omitempty"`
+	Error     string             `json:"error,omitempty"`
+	CreatedAt time.Time          `json:"created_at"`
+	UpdatedAt time.Time          `json:"updated_at"`
+}
+
+// Store keeps records in directory. Store has no in-memory state, so
+// overseer and users utility can work with the same directory.
+type Store struct {
+	dirPath string
+}
+
+func Open(dirPath string) (*Store, error) {
+	err := os.MkdirAll(dirPath, 0775)
+	if err != nil {
+		return nil, fmt.Errorf("create directory: %w", err)
+	}
+	return &Store{dirPath: dirPath}, nil
+}
+
+func newID() (string, error) {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return time.Now().Format("20060102150405") + "-" + hex.EncodeToString(b), nil
+}
+
+func (s *Store) recordFilePath(id string) string {
+	return filepath.Join(s.dirPath, id+recordFileExt)
+}
+
+// PhotoFilePath returns path of the record photo.
+func (s *Store) PhotoFilePath(id string) string {
+	return filepath.Join(s.dirPath, id+photoFileExt)
+}
+
+func (s *Store) save(r Record) error {
+	r.UpdatedAt = time.Now()
+
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("JSON encode record: %w", err)
+	}
+
+	tmpFilePath := s.recordFilePath(r.ID) + ".tmp"
+
+	err = ioutil.WriteFile(tmpFilePath, data, 0664)
+	if err != nil {
+		return fmt.Errorf("write record file: %w", err)
+	}
+
+	err = os.Rename(tmpFilePath, s.recordFilePath(r.ID))
+	if err != nil {
+		return fmt.Errorf("rename record file: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) Get(id string) (Record, error) {
+	data, err := ioutil.ReadFile(s.recordFilePath(id))
+	if os.IsNotExist(err) {
+		return Record{}, ErrRecordNotFound
+	}
+	if err != nil {
+		return Record{}, fmt.Errorf("read record file: %w", err)
+	}
+
+	var r Record
+
+	err = json.Unmarshal(data, &r)
+	if err != nil {
+		return Record{}, fmt.Errorf("JSON decode record file: %w", err)
+	}
+
+	return r, nil
+}
+
+// Records returns records of the user or of all users if userID is empty,
+// oldest first.
+func (s *Store) Records(userID string) ([]Record, error) {
+	fis, err := ioutil.ReadDir(s.dirPath)
+	if err != nil {
+		return nil, fmt.Errorf("read directory: %w", err)
+	}
+
+	var rs []Record
+
+	for _, fi := range fis {
+		if fi.IsDir() || filepath.Ext(fi.Name()) != recordFileExt {
+			continue
+		}
+
+		r, err := s.Get(strings.TrimSuffix(fi.Name(), recordFileExt))
+		if err != nil {
+			return nil, fmt.Errorf("get record %s: %w", fi.Name(), err)
+		}
+
+		if userID != "" && r.UserID != userID {
+			continue
+		}
+
+		rs = append(rs, r)
+	}
+
+	sort.Slice(rs, func(i, j int) bool {
+		return rs[i].CreatedAt.Before(rs[j].CreatedAt)
+	})
+
+	return rs, nil
+}
+
+// Create stores photo and its pending record. Record ID is set.
+func (s *Store) Create(r Record, photo []byte) (Record, error) {
+	id, err := newID()
+	if err != nil {
+		return Record{}, fmt.Errorf("generate ID: %w", err)
+	}
+
+	r.ID = id
+	r.Status = StatusPending
+	r.CreatedAt = time.Now()
+
+	err = ioutil.WriteFile(s.PhotoFilePath(id), photo, 0664)
+	if err != nil {
+		return Record{}, fmt.Errorf("write photo file: %w", err)
+	}
+
+	err = s.save(r)
+	if err != nil {
+		os.Remove(s.PhotoFilePath(id))
+		return Record{}, err
+	}
+
+	return r, nil
+}
+
+// Approve adds photo of the pending record to the user's gallery.
+func (s *Store) Approve(ctx context.Context, e face.Enroller, id string) (Record, error) {
+	r, err := s.Get(id)
+	if err != nil {
+		return Record{}, err
+	}
+
+	if r.Status != StatusPending {
+		return r, fmt.Errorf("record is %s, not %s", r.Status, StatusPending)
+	}
+
+	r.PhotoID, err = e.AddUserPhoto(ctx, r.UserID, s.PhotoFilePath(id))
+	if err != nil {
+		r.Error = err.Error()
+		s.save(r)
+		return r, fmt.Errorf("add user photo: %w", err)
+	}
+
+	r.Status = StatusAdded
+	r.Error = ""
+
+	return r, s.save(r)
+}
+
+// Reject rejects pending record. Photo is kept for audit.
+func (s *Store) Reject(id string) (Record, error) {
+	r, err := s.Get(id)
+	if err != nil {
+		return Record{}, err
+	}
+
+	if r.Status != StatusPending {
+		return r, fmt.Errorf("record is %s, not %s", r.Status, StatusPending)
+	}
+
+	r.Status = StatusRejected
+
+	return r, s.save(r)
+}
+
+// Rollback removes photo of the added record from the user's gallery.
+func (s *Store) Rollback(ctx context.Context, e face.Enroller, id string) (Record, error) {
+	r, err := s.Get(id)
+	if err != nil {
+		return Record{}, err
+	}
+
+	if r.Status != StatusAdded {
+		return r, fmt.Errorf("record is %s, not %s", r.Status, StatusAdded)
+	}
+
+	err = e.RemoveUserPhoto(ctx, r.UserID, r.PhotoID)
+	if err != nil && !errors.Is(err, face.ErrPhotoNotFound) {
+		return r, fmt.Errorf("remove user photo: %w", err)
+	}
+
+	r.Status = StatusRolledBack
+
+	return r, s.save(r)
+}
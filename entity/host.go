@@ -2,6 +2,7 @@ package entity
 
 import (
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net"
@@ -9,21 +10,52 @@ import (
 	"time"
 )
 
+type RecognitionOutcome string
+
+const (
+	OutcomeAuthorized     RecognitionOutcome = "authorized"
+	OutcomeUnauthorized   RecognitionOutcome = "unauthorized"
+	OutcomeUncertain      RecognitionOutcome = "uncertain"
+	OutcomeNoFace         RecognitionOutcome = "no_face"
+	OutcomeSpoof          RecognitionOutcome = "spoof_suspected"
+	OutcomeError          RecognitionOutcome = "recognition_error"
+	OutcomePaused         RecognitionOutcome = "recognition_paused"
+	OutcomeCameraTampered RecognitionOutcome = "camera_tampered"
+)
+
 type HostStatus struct {
-	Online      bool      `json:"online"`
-	AgentOnline bool      `json:"agent_online"`
-	ActiveUser  string    `json:"active_user"`
-	UpdatedAt   time.Time `json:"updated_at"`
-	Error       string    `json:"error"`
+	Name                 string             `json:"name"`
+	Online               bool               `json:"online"`
+	AgentOnline          bool               `json:"agent_online"`
+	ActiveUser           string             `json:"active_user"`
+	RecognizedUserID     string             `json:"recognized_user_id"`
+	Score                float64            `json:"score"`
+	Outcome              RecognitionOutcome `json:"outcome"`
+	FacesCount           int                `json:"faces_count"`
+	FaceAPIDegraded      bool               `json:"face_api_degraded"`
+	RecognitionErrors    int                `json:"recognition_errors"`
+	ErrorDecision        string             `json:"error_decision"`
+	ErrorCategory        string             `json:"error_category"`
+	FrameReuseHits       int                `json:"frame_reuse_hits"`
+	FrameReuseMisses     int                `json:"frame_reuse_misses"`
+	AbsentSince          time.Time          `json:"absent_since"`
+	UnknownFaceStrikes   int                `json:"unknown_face_strikes"`
+	DifferentUserStrikes int                `json:"different_user_strikes"`
+	UpdatedAt            time.Time          `json:"updated_at"`
+	Error                string             `json:"error"`
 }
 
 type Host struct {
-	Name            string            `yaml:"name"`
-	OnlineCheckPort int               `yaml:"online_check_port"`
-	AgentHost       string            `yaml:"agent_host"`
-	AgentPort       int               `yaml:"agent_port"`
-	CameraID        int               `yaml:"camera_id"`
-	Users           map[string]string `yaml:"users"`
+	Name                string            `yaml:"name"`
+	Group               string            `yaml:"group,omitempty"`
+	OnlineCheckPort     int               `yaml:"online_check_port"`
+	AgentHost           string            `yaml:"agent_host"`
+	AgentPort           int               `yaml:"agent_port"`
+	CameraID            int               `yaml:"camera_id"`
+	AcceptanceThreshold float64           `yaml:"acceptance_threshold,omitempty"`
+	RejectionThreshold  float64           `yaml:"rejection_threshold,omitempty"`
+	AllowedBystanders   []string          `yaml:"allowed_bystanders,omitempty"`
+	Users               map[string]string `yaml:"users"`
 }
 
 func (h Host) CheckOnline(timeout time.Duration) bool {
@@ -69,12 +101,58 @@ func (h Host) Status() (io.ReadCloser, string, error) {
 	return res.Body, userName, nil
 }
 
+type framesResponse struct {
+	Frames [][]byte `json:"frames"`
+}
+
+// Frames requests burst of JPEG encoded camera frames taken with the given
+// interval.
+func (h Host) Frames(count int, interval time.Duration) ([][]byte, error) {
+	res, err := http.Get(fmt.Sprintf("http://%s:%d/frames?count=%d&interval=%d",
+		h.Name, h.AgentPort, count, interval/time.Millisecond))
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("not 200 status code: %d", res.StatusCode)
+	}
+
+	var fr framesResponse
+
+	err = json.NewDecoder(res.Body).Decode(&fr)
+	if err != nil {
+		return nil, fmt.Errorf("JSON decode frames: %w", err)
+	}
+
+	return fr.Frames, nil
+}
+
 func (h Host) LogoutCurrentUser() error {
 	res, err := http.Post(fmt.Sprintf("http://%s:%d/logout", h.Name, h.AgentPort), "", nil)
 	if err != nil {
 		return err
 	}
 
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("not 200 status code: %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+func (h Host) LockWorkstation() error {
+	res, err := http.Post(fmt.Sprintf("http://%s:%d/lock", h.Name, h.AgentPort), "", nil)
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
 	if res.StatusCode != http.StatusOK {
 		return fmt.Errorf("not 200 status code: %d", res.StatusCode)
 	}
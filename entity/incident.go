@@ -0,0 +1,30 @@
+package entity
+
+import "time"
+
+type IncidentType string
+
+const (
+	IncidentBystanderPresent     IncidentType = "bystander_present"
+	IncidentSpoofSuspected       IncidentType = "spoof_suspected"
+	IncidentCameraTampered       IncidentType = "camera_tampered"
+	IncidentUserAbsent           IncidentType = "user_absent"
+	IncidentCredentialSharing    IncidentType = "credential_sharing"
+	IncidentUnknownPerson        IncidentType = "unknown_person"
+	IncidentUserNotAllowed       IncidentType = "user_not_allowed"
+	IncidentFaceOnMultipleHosts  IncidentType = "face_on_multiple_hosts"
+	IncidentAccountMultipleFaces IncidentType = "account_multiple_faces"
+)
+
+type Incident struct {
+	Type     IncidentType `json:"type"`
+	HostName string       `json:"host_name"`
+	UserName string       `json:"user_name"`
+	// Condition distinguishes incidents of the same type, host and user,
+	// for example detected user or reasons. It must not contain scores or
+	// durations, since incidents are deduplicated by it. Incidents without
+	// condition are never deduplicated.
+	Condition string    `json:"condition,omitempty"`
+	Message   string    `json:"message"`
+	Time      time.Time `json:"time"`
+}
@@ -1,70 +1,287 @@
 package face
 
 import (
-	"errors"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
 	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 )
 
 type APIConfig struct {
-	// Some config.
+	URL   string `yaml:"url"`
+	Token string `yaml:"token"`
+
+	// Cassette records face API traffic or replays it instead of calling
+	// face API.
+	Cassette CassetteConfig `yaml:"cassette"`
 }
 
 type API struct {
-	config APIConfig
+	config   APIConfig
+	client   *http.Client
+	cassette *Cassette
+}
+
+func NewAPI(c APIConfig) (*API, error) {
+	a := &API{config: c, client: &http.Client{}}
+
+	if c.Cassette.Path != "" {
+		cs, err := NewCassette(c.Cassette, http.DefaultTransport)
+		if err != nil {
+			return nil, fmt.Errorf("create cassette: %w", err)
+		}
+		a.client.Transport = cs
+		a.cassette = cs
+	}
+
+	return a, nil
+}
+
+// Close closes cassette if it is used.
+func (a *API) Close() error {
+	if a.cassette == nil {
+		return nil
+	}
+	return a.cassette.Close()
+}
+
+func init() {
+	Register(DefaultBackend, func(decode func(interface{}) error) (Backend, error) {
+		var c APIConfig
+		err := decode(&c)
+		if err != nil {
+			return nil, fmt.Errorf("decode config: %w", err)
+		}
+		return NewAPI(c)
+	})
+}
+
+type errorResponseData struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (a *API) do(ctx context.Context, method, path, contentType string, body io.Reader, resData interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(a.config.URL, "/")+path, body)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	if a.config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.config.Token)
+	}
+
+	res, err := a.client.Do(req)
+	if err != nil {
+		return &Error{Category: CategoryUnavailable, Err: err}
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		var errData errorResponseData
+		json.NewDecoder(res.Body).Decode(&errData)
+		return &Error{
+			Category:   categoryByResponse(res.StatusCode, errData.Code),
+			StatusCode: res.StatusCode,
+			Message:    errData.Message,
+		}
+	}
+
+	err = json.NewDecoder(res.Body).Decode(resData)
+	if err != nil {
+		return fmt.Errorf("JSON decode response: %w", err)
+	}
+
+	return nil
 }
 
-func NewAPI(c APIConfig) *API {
-	return &API{config: c}
+func (a *API) postPhoto(ctx context.Context, path string, photoFilePath string, resData interface{}) error {
+	f, err := os.Open(photoFilePath)
+	if err != nil {
+		return fmt.Errorf("open photo file: %w", err)
+	}
+	defer f.Close()
+
+	var body bytes.Buffer
+
+	w := multipart.NewWriter(&body)
+
+	fw, err := w.CreateFormFile("photo", filepath.Base(photoFilePath))
+	if err != nil {
+		return fmt.Errorf("create multipart form file: %w", err)
+	}
+
+	_, err = io.Copy(fw, f)
+	if err != nil {
+		return fmt.Errorf("read photo file: %w", err)
+	}
+
+	err = w.Close()
+	if err != nil {
+		return fmt.Errorf("close multipart writer: %w", err)
+	}
+
+	return a.do(ctx, http.MethodPost, path, w.FormDataContentType(), &body, resData)
 }
 
-const addUserPath = ""
+const addUserPath = "/users"
 
 type addUserResponseData struct {
-	// Some data.
+	UserID string `json:"user_id"`
 }
 
-func (a *API) AddUser(photoFilePath string) (string, error) {
-	// Some algorithm.
-	return "", nil
+func (a *API) AddUser(ctx context.Context, photoFilePath string) (string, error) {
+	var resData addUserResponseData
+
+	err := a.postPhoto(ctx, addUserPath, photoFilePath, &resData)
+	if err != nil {
+		return "", err
+	}
+
+	return resData.UserID, nil
 }
 
-const addUserPhotoPath = "/some/path"
+const addUserPhotoPath = "/users/%s/photos"
 
 type addUserPhotoResponseData struct {
-	// Some data.
+	PhotoID string `json:"photo_id"`
+}
+
+func (a *API) AddUserPhoto(ctx context.Context, userID string, photoFilePath string) (string, error) {
+	var resData addUserPhotoResponseData
+
+	err := a.postPhoto(ctx, fmt.Sprintf(addUserPhotoPath, userID), photoFilePath, &resData)
+	if err != nil {
+		return "", err
+	}
+
+	return resData.PhotoID, nil
+}
+
+const removeUserPhotoPath = "/users/%s/photos/remove"
+
+type removeUserPhotoRequestData struct {
+	PhotoID string `json:"photo_id"`
 }
 
-func (a *API) AddUserPhoto(userID string, photoFilePath string) error {
-	// Some algorithm.
+func (a *API) RemoveUserPhoto(ctx context.Context, userID string, photoID string) error {
+	reqData, err := json.Marshal(removeUserPhotoRequestData{PhotoID: photoID})
+	if err != nil {
+		return fmt.Errorf("JSON encode request: %w", err)
+	}
+
+	var resData removeUserResponseData
+
+	err = a.do(ctx, http.MethodPost, fmt.Sprintf(removeUserPhotoPath, userID), "application/json", bytes.NewReader(reqData), &resData)
+	if err != nil {
+		return err
+	}
+
+	if !resData.Removed {
+		return ErrPhotoNotFound
+	}
+
 	return nil
 }
 
-const recognizeUserPath = "/some/path"
+const recognizeUserPath = "/recognize"
 
 type recognizeUserResponseData struct {
-	// Some data.
+	Box struct {
+		X      int `json:"x"`
+		Y      int `json:"y"`
+		Width  int `json:"width"`
+		Height int `json:"height"`
+	} `json:"box"`
+	Candidates []Candidate `json:"candidates"`
 }
 
-var (
-	ErrFaceNotFound = errors.New("face not found")
-)
+func (d recognizeUserResponseData) recognition() Recognition {
+	b := d.Box
+
+	r := Recognition{
+		Box:        image.Rect(b.X, b.Y, b.X+b.Width, b.Y+b.Height),
+		Candidates: d.Candidates,
+	}
+
+	sortCandidates(r.Candidates)
+
+	return r
+}
+
+func (a *API) RecognizeUser(ctx context.Context, photo io.Reader) (Recognition, error) {
+	var resData recognizeUserResponseData
 
-func (a *API) RecognizeUser(photo io.Reader) (string, error) {
-	// Some algorithm.
-	return "", nil
+	err := a.do(ctx, http.MethodPost, recognizeUserPath, "image/jpeg", photo, &resData)
+	if err != nil {
+		return Recognition{}, err
+	}
+
+	return resData.recognition(), nil
+}
+
+const recognizeUsersPath = "/recognize_all"
+
+type recognizeUsersResponseData struct {
+	Faces []recognizeUserResponseData `json:"faces"`
 }
 
-const removeUserRequestPath = "/some/path"
+func (a *API) RecognizeUsers(ctx context.Context, photo io.Reader) ([]Recognition, error) {
+	var resData recognizeUsersResponseData
+
+	err := a.do(ctx, http.MethodPost, recognizeUsersPath, "image/jpeg", photo, &resData)
+	if err != nil {
+		return nil, err
+	}
+
+	var rs []Recognition
+
+	for _, f := range resData.Faces {
+		rs = append(rs, f.recognition())
+	}
+
+	sortRecognitions(rs)
+
+	return rs, nil
+}
+
+const removeUserRequestPath = "/users/remove"
 
 type removeUserRequestData struct {
-	// Some data.
+	UserID string `json:"user_id"`
 }
 
 type removeUserResponseData struct {
-	// Some data.
+	Removed bool `json:"removed"`
 }
 
-func (a *API) RemoveUser(userID string) error {
-	// Some algorithm.
+func (a *API) RemoveUser(ctx context.Context, userID string) error {
+	reqData, err := json.Marshal(removeUserRequestData{UserID: userID})
+	if err != nil {
+		return fmt.Errorf("JSON encode request: %w", err)
+	}
+
+	var resData removeUserResponseData
+
+	err = a.do(ctx, http.MethodPost, removeUserRequestPath, "application/json", bytes.NewReader(reqData), &resData)
+	if err != nil {
+		return err
+	}
+
+	if !resData.Removed {
+		return ErrUserNotFound
+	}
+
 	return nil
 }
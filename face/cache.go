@@ -0,0 +1,314 @@
+package face
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"sync/atomic"
+)
+
+const (
+	defaultCacheUnknownScore         = 0.95
+	defaultCacheMinMargin            = 0.03
+	defaultCacheLearnScore           = 0.9
+	defaultCacheDedupScore           = 0.98
+	defaultCacheMaxEmbeddingsPerUser = 20
+	defaultCacheMaxCandidates        = 5
+)
+
+// CacheConfig configures local embedding cache. Cache is disabled when index
+// file path is not set.
+type CacheConfig struct {
+	IndexFilePath string `yaml:"index_file_path"`
+
+	// UnknownScore is a local similarity which faces of not enrolled people
+	// reach against the index, it should be calibrated on real camera frames.
+	// Cache answers locally only if the best candidate beats both UnknownScore
+	// and the second candidate by MinMargin.
+	UnknownScore float64 `yaml:"unknown_score"`
+	MinMargin    float64 `yaml:"min_margin"`
+
+	// LearnScore is a minimal remote backend score to cache embedding of the
+	// recognized face.
+	LearnScore           float64 `yaml:"learn_score"`
+	DedupScore           float64 `yaml:"dedup_score"`
+	MaxEmbeddingsPerUser int     `yaml:"max_embeddings_per_user"`
+	MaxCandidates        int     `yaml:"max_candidates"`
+
+	IndexTables int `yaml:"index_tables"`
+	IndexBits   int `yaml:"index_bits"`
+}
+
+func (c *CacheConfig) setDefaults() {
+	if c.UnknownScore == 0 {
+		c.UnknownScore = defaultCacheUnknownScore
+	}
+	if c.MinMargin == 0 {
+		c.MinMargin = defaultCacheMinMargin
+	}
+	if c.LearnScore == 0 {
+		c.LearnScore = defaultCacheLearnScore
+	}
+	if c.DedupScore == 0 {
+		c.DedupScore = defaultCacheDedupScore
+	}
+	if c.MaxEmbeddingsPerUser == 0 {
+		c.MaxEmbeddingsPerUser = defaultCacheMaxEmbeddingsPerUser
+	}
+	if c.MaxCandidates == 0 {
+		c.MaxCandidates = defaultCacheMaxCandidates
+	}
+	if c.IndexTables == 0 {
+		c.IndexTables = defaultIndexTables
+	}
+	if c.IndexBits == 0 {
+		c.IndexBits = defaultIndexBits
+	}
+}
+
+type CacheStats struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+}
+
+// Cached answers high confidence matches from the local embeddings index and
+// falls back to the backend otherwise. Index is filled with embeddings of
+// enrolled photos and of faces confidently recognized by the backend.
+// Recognition of all faces on the photo is answered locally only if every face
+// found by the local detector is confidently matched.
+type Cached struct {
+	config  CacheConfig
+	backend Backend
+	index   *index
+
+	hits   uint64
+	misses uint64
+}
+
+func NewCached(b Backend, c CacheConfig) (*Cached, error) {
+	if c.IndexFilePath == "" {
+		return nil, fmt.Errorf("index_file_path is not set")
+	}
+
+	c.setDefaults()
+
+	i, err := openIndex(c.IndexFilePath, c.IndexTables, c.IndexBits)
+	if err != nil {
+		return nil, fmt.Errorf("open index: %w", err)
+	}
+
+	return &Cached{config: c, backend: b, index: i}, nil
+}
+
+func (c *Cached) Close() error {
+	return Close(c.backend)
+}
+
+func (c *Cached) Stats() CacheStats {
+	return CacheStats{
+		Hits:   atomic.LoadUint64(&c.hits),
+		Misses: atomic.LoadUint64(&c.misses),
+	}
+}
+
+// Degraded reports backend degradation, cache itself never degrades.
+func (c *Cached) Degraded() bool {
+	if d, ok := c.backend.(Degradable); ok {
+		return d.Degraded()
+	}
+	return false
+}
+
+// match returns local recognition of the face if it is confident.
+func (c *Cached) match(img image.Image, box image.Rectangle) (Recognition, bool) {
+	cs := c.index.search(Embed(img, box))
+	if len(cs) == 0 {
+		return Recognition{}, false
+	}
+
+	// Single enrolled user has no competitors, so the best candidate is
+	// always compared with the unknown face score too.
+	rival := c.config.UnknownScore
+	if len(cs) > 1 && cs[1].Score > rival {
+		rival = cs[1].Score
+	}
+
+	if cs[0].Score-rival < c.config.MinMargin {
+		return Recognition{}, false
+	}
+
+	if len(cs) > c.config.MaxCandidates {
+		cs = cs[:c.config.MaxCandidates]
+	}
+
+	return Recognition{Box: box, Candidates: cs}, true
+}
+
+// learn caches embedding of the only face on the photo if backend
+// confidently recognized it.
+func (c *Cached) learn(img image.Image, faces []image.Rectangle, rs []Recognition) error {
+	if len(faces) != 1 || len(rs) != 1 {
+		return nil
+	}
+
+	best, ok := rs[0].Best()
+	if !ok || best.Score < c.config.LearnScore {
+		return nil
+	}
+
+	_, err := c.index.add(best.UserID, Embed(img, faces[0]), false, "",
+		c.config.DedupScore, c.config.MaxEmbeddingsPerUser)
+	if err != nil {
+		return fmt.Errorf("add embedding to index: %w", err)
+	}
+
+	return nil
+}
+
+// decodeFaces decodes photo and detects faces for local matching. Photos
+// which can't be decoded or have no faces detected locally are left to the
+// backend.
+func decodeFaces(p []byte) (image.Image, []image.Rectangle) {
+	img, _, err := image.Decode(bytes.NewReader(p))
+	if err != nil {
+		return nil, nil
+	}
+	return img, Detect(img)
+}
+
+func (c *Cached) RecognizeUser(ctx context.Context, photo io.Reader) (Recognition, error) {
+	p, err := readPhoto(photo)
+	if err != nil {
+		return Recognition{}, err
+	}
+
+	img, faces := decodeFaces(p)
+
+	if len(faces) > 0 {
+		if r, ok := c.match(img, faces[0]); ok {
+			atomic.AddUint64(&c.hits, 1)
+			return r, nil
+		}
+	}
+
+	atomic.AddUint64(&c.misses, 1)
+
+	r, err := c.backend.RecognizeUser(ctx, bytes.NewReader(p))
+	if err != nil {
+		return Recognition{}, err
+	}
+
+	// Cache errors must not fail recognition.
+	c.learn(img, faces, []Recognition{r})
+
+	return r, nil
+}
+
+// matchAll returns local recognitions of all faces if every one of them is
+// confident.
+func (c *Cached) matchAll(img image.Image, faces []image.Rectangle) ([]Recognition, bool) {
+	if len(faces) == 0 {
+		return nil, false
+	}
+
+	rs := make([]Recognition, 0, len(faces))
+
+	for _, f := range faces {
+		r, ok := c.match(img, f)
+		if !ok {
+			return nil, false
+		}
+		rs = append(rs, r)
+	}
+
+	return rs, true
+}
+
+func (c *Cached) RecognizeUsers(ctx context.Context, photo io.Reader) ([]Recognition, error) {
+	p, err := readPhoto(photo)
+	if err != nil {
+		return nil, err
+	}
+
+	img, faces := decodeFaces(p)
+
+	if rs, ok := c.matchAll(img, faces); ok {
+		atomic.AddUint64(&c.hits, 1)
+		return rs, nil
+	}
+
+	atomic.AddUint64(&c.misses, 1)
+
+	rs, err := RecognizeUsers(ctx, c.backend, bytes.NewReader(p))
+	if err != nil {
+		return nil, err
+	}
+
+	// Cache errors must not fail recognition.
+	c.learn(img, faces, rs)
+
+	return rs, nil
+}
+
+func (c *Cached) enroll(userID, photoID, photoFilePath string) {
+	e, err := embedPhotoFile(photoFilePath)
+	if err != nil {
+		return
+	}
+
+	c.index.add(userID, e, true, photoID, c.config.DedupScore, c.config.MaxEmbeddingsPerUser)
+}
+
+func (c *Cached) AddUser(ctx context.Context, photoFilePath string) (string, error) {
+	userID, err := c.backend.AddUser(ctx, photoFilePath)
+	if err != nil {
+		return "", err
+	}
+
+	c.enroll(userID, "", photoFilePath)
+
+	return userID, nil
+}
+
+func (c *Cached) AddUserPhoto(ctx context.Context, userID string, photoFilePath string) (string, error) {
+	photoID, err := c.backend.AddUserPhoto(ctx, userID, photoFilePath)
+	if err != nil {
+		return "", err
+	}
+
+	c.enroll(userID, photoID, photoFilePath)
+
+	return photoID, nil
+}
+
+func (c *Cached) RemoveUserPhoto(ctx context.Context, userID string, photoID string) error {
+	err := c.backend.RemoveUserPhoto(ctx, userID, photoID)
+	if err != nil && Category(err) != CategoryPhotoNotFound {
+		return err
+	}
+
+	// Learned embeddings could be similar to the removed photo, so they are
+	// removed too.
+	iErr := c.index.removePhoto(userID, photoID)
+	if iErr != nil {
+		return fmt.Errorf("remove photo from index: %w", iErr)
+	}
+
+	return err
+}
+
+func (c *Cached) RemoveUser(ctx context.Context, userID string) error {
+	err := c.backend.RemoveUser(ctx, userID)
+	if err != nil && Category(err) != CategoryUserNotFound {
+		return err
+	}
+
+	iErr := c.index.removeUser(userID)
+	if iErr != nil {
+		return fmt.Errorf("remove user from index: %w", iErr)
+	}
+
+	return err
+}
@@ -0,0 +1,275 @@
+package face
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	CassetteRecord = "record"
+	CassetteReplay = "replay"
+)
+
+// ErrCassetteMiss is returned in replay mode when cassette has no more
+// interactions for the request.
+var ErrCassetteMiss = errors.New("no recorded interaction for request")
+
+// CassetteConfig configures cassette. MatchByPath allows replay of
+// interaction with the same method and path but different request body, so
+// cassette recorded with one set of camera frames can serve another.
+type CassetteConfig struct {
+	Path        string `yaml:"path"`
+	Mode        string `yaml:"mode"`
+	MatchByPath bool   `yaml:"match_by_path"`
+}
+
+// Interaction is a recorded face API request and its response. Request
+// headers are not recorded, so cassette never contains API token.
+type Interaction struct {
+	Time        time.Time `json:"time"`
+	Method      string    `json:"method"`
+	Path        string    `json:"path"`
+	ContentType string    `json:"content_type,omitempty"`
+	RequestHash string    `json:"request_hash"`
+	Request     []byte    `json:"request,omitempty"`
+	StatusCode  int       `json:"status_code,omitempty"`
+	Response    []byte    `json:"response,omitempty"`
+	Error       string    `json:"error,omitempty"`
+
+	replayed bool
+}
+
+// Cassette is a http.RoundTripper which records face API interactions to
+// JSON lines file or replays them from it.
+//
+// In replay mode request is served by the first not yet replayed interaction
+// with the same method, path and request body. Multipart bodies are compared
+// by their parts, because boundaries differ between runs. Request without
+// such interaction fails unless cassette matches by path.
+type Cassette struct {
+	mode        string
+	matchByPath bool
+	transport   http.RoundTripper
+
+	mx           sync.Mutex
+	file         *os.File
+	interactions []*Interaction
+}
+
+// NewCassette creates cassette. Record mode truncates cassette file and
+// passes requests to the transport.
+func NewCassette(c CassetteConfig, transport http.RoundTripper) (*Cassette, error) {
+	cs := &Cassette{mode: c.Mode, matchByPath: c.MatchByPath, transport: transport}
+
+	switch c.Mode {
+	case CassetteRecord:
+		f, err := os.Create(c.Path)
+		if err != nil {
+			return nil, fmt.Errorf("create cassette file: %w", err)
+		}
+		cs.file = f
+
+	case CassetteReplay:
+		f, err := os.Open(c.Path)
+		if err != nil {
+			return nil, fmt.Errorf("open cassette file: %w", err)
+		}
+		defer f.Close()
+
+		s := bufio.NewScanner(f)
+		s.Buffer(nil, 64<<20)
+
+		for s.Scan() {
+			if len(bytes.TrimSpace(s.Bytes())) == 0 {
+				continue
+			}
+			var i Interaction
+			err = json.Unmarshal(s.Bytes(), &i)
+			if err != nil {
+				return nil, fmt.Errorf("JSON decode cassette interaction: %w", err)
+			}
+			cs.interactions = append(cs.interactions, &i)
+		}
+
+		if err = s.Err(); err != nil {
+			return nil, fmt.Errorf("read cassette file: %w", err)
+		}
+
+	default:
+		return nil, fmt.Errorf("unknown cassette mode %q", c.Mode)
+	}
+
+	return cs, nil
+}
+
+// Close closes cassette file in record mode. Replay mode reads the file on
+// creation and keeps nothing open.
+func (c *Cassette) Close() error {
+	if c.file == nil {
+		return nil
+	}
+	return c.file.Close()
+}
+
+// requestHash returns hash of the request body. Multipart body is hashed by
+// parts names, file names and contents, so it doesn't depend on boundary.
+func requestHash(contentType string, body []byte) string {
+	h := sha256.New()
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err == nil && strings.HasPrefix(mediaType, "multipart/") {
+		if ok := hashParts(h, multipart.NewReader(bytes.NewReader(body), params["boundary"])); ok {
+			return hex.EncodeToString(h.Sum(nil))
+		}
+		h.Reset()
+	}
+
+	h.Write(body)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func hashParts(h hash.Hash, r *multipart.Reader) bool {
+	for {
+		p, err := r.NextPart()
+		if err == io.EOF {
+			return true
+		}
+		if err != nil {
+			return false
+		}
+
+		fmt.Fprintf(h, "%q %q %q\n", p.FormName(), p.FileName(), p.Header.Get("Content-Type"))
+
+		_, err = io.Copy(h, p)
+		if err != nil {
+			return false
+		}
+	}
+}
+
+func (c *Cassette) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("read request body: %w", err)
+		}
+	}
+
+	if c.mode == CassetteReplay {
+		return c.replay(req, body)
+	}
+
+	return c.record(req, body)
+}
+
+func (c *Cassette) record(req *http.Request, body []byte) (*http.Response, error) {
+	i := Interaction{
+		Time:        time.Now(),
+		Method:      req.Method,
+		Path:        req.URL.Path,
+		ContentType: req.Header.Get("Content-Type"),
+		RequestHash: requestHash(req.Header.Get("Content-Type"), body),
+		Request:     body,
+	}
+
+	req = req.Clone(req.Context())
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+
+	res, err := c.transport.RoundTrip(req)
+	if err != nil {
+		i.Error = err.Error()
+	} else {
+		i.StatusCode = res.StatusCode
+		i.Response, err = ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("read response body: %w", err)
+		}
+		res.Body = ioutil.NopCloser(bytes.NewReader(i.Response))
+	}
+
+	line, jErr := json.Marshal(i)
+	if jErr != nil {
+		return nil, fmt.Errorf("JSON encode cassette interaction: %w", jErr)
+	}
+
+	c.mx.Lock()
+	_, wErr := c.file.Write(append(line, '\n'))
+	c.mx.Unlock()
+
+	if wErr != nil {
+		return nil, fmt.Errorf("write cassette interaction: %w", wErr)
+	}
+
+	return res, err
+}
+
+func (c *Cassette) next(method, path, hash string) *Interaction {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	var fallback *Interaction
+
+	for _, i := range c.interactions {
+		if i.replayed || i.Method != method || i.Path != path {
+			continue
+		}
+		if i.RequestHash == hash {
+			i.replayed = true
+			return i
+		}
+		if fallback == nil && c.matchByPath {
+			fallback = i
+		}
+	}
+
+	if fallback != nil {
+		fallback.replayed = true
+	}
+
+	return fallback
+}
+
+func (c *Cassette) replay(req *http.Request, body []byte) (*http.Response, error) {
+	i := c.next(req.Method, req.URL.Path, requestHash(req.Header.Get("Content-Type"), body))
+	if i == nil {
+		return nil, fmt.Errorf("%s %s: %w", req.Method, req.URL.Path, ErrCassetteMiss)
+	}
+
+	if i.Error != "" {
+		return nil, errors.New(i.Error)
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", i.StatusCode, http.StatusText(i.StatusCode)),
+		StatusCode:    i.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"application/json"}},
+		Body:          ioutil.NopCloser(bytes.NewReader(i.Response)),
+		ContentLength: int64(len(i.Response)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,125 @@
+package face
+
+import (
+	"image"
+	"image/color"
+	"sort"
+)
+
+const (
+	detectMaxSide      = 160
+	detectMinFaceSide  = 12
+	detectMinFill      = 0.4
+	detectMinAspect    = 0.8
+	detectMaxAspect    = 2.2
+	detectFaceMaxRatio = 1.3
+)
+
+func isSkin(c color.Color) bool {
+	r, g, b, _ := c.RGBA()
+	y, cb, cr := color.RGBToYCbCr(uint8(r>>8), uint8(g>>8), uint8(b>>8))
+	return y > 40 && cb >= 77 && cb <= 127 && cr >= 133 && cr <= 173
+}
+
+// Detect finds faces on the image using skin color segmentation and returns
+// their bounding boxes ordered by area, largest first.
+func Detect(img image.Image) []image.Rectangle {
+	bounds := img.Bounds()
+
+	scale := 1
+	for bounds.Dx()/scale > detectMaxSide || bounds.Dy()/scale > detectMaxSide {
+		scale++
+	}
+
+	w, h := bounds.Dx()/scale, bounds.Dy()/scale
+
+	mask := make([]bool, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			mask[y*w+x] = isSkin(img.At(bounds.Min.X+x*scale, bounds.Min.Y+y*scale))
+		}
+	}
+
+	var (
+		faces   []image.Rectangle
+		visited = make([]bool, w*h)
+		queue   []int
+	)
+
+	for i := range mask {
+		if !mask[i] || visited[i] {
+			continue
+		}
+
+		visited[i] = true
+		queue = append(queue[:0], i)
+
+		minX, minY, maxX, maxY := w, h, 0, 0
+		count := 0
+
+		for len(queue) > 0 {
+			j := queue[len(queue)-1]
+			queue = queue[:len(queue)-1]
+			count++
+
+			x, y := j%w, j/w
+			if x < minX {
+				minX = x
+			}
+			if x > maxX {
+				maxX = x
+			}
+			if y < minY {
+				minY = y
+			}
+			if y > maxY {
+				maxY = y
+			}
+
+			for _, n := range [4][2]int{{x - 1, y}, {x + 1, y}, {x, y - 1}, {x, y + 1}} {
+				if n[0] < 0 || n[0] >= w || n[1] < 0 || n[1] >= h {
+					continue
+				}
+				k := n[1]*w + n[0]
+				if mask[k] && !visited[k] {
+					visited[k] = true
+					queue = append(queue, k)
+				}
+			}
+		}
+
+		bw, bh := maxX-minX+1, maxY-minY+1
+		if bw < detectMinFaceSide || bh < detectMinFaceSide {
+			continue
+		}
+
+		if float64(count)/float64(bw*bh) < detectMinFill {
+			continue
+		}
+
+		aspect := float64(bh) / float64(bw)
+		if aspect < detectMinAspect || aspect > detectMaxAspect {
+			continue
+		}
+
+		// Skin region usually includes neck, so cut it off.
+		if aspect > detectFaceMaxRatio {
+			bh = int(float64(bw) * detectFaceMaxRatio)
+		}
+
+		faces = append(faces, image.Rect(
+			bounds.Min.X+minX*scale, bounds.Min.Y+minY*scale,
+			bounds.Min.X+(minX+bw)*scale, bounds.Min.Y+(minY+bh)*scale,
+		).Intersect(bounds))
+	}
+
+	sort.Slice(faces, func(i, j int) bool {
+		return area(faces[i]) > area(faces[j])
+	})
+
+	return faces
+}
+
+func area(r image.Rectangle) int {
+	return r.Dx() * r.Dy()
+}
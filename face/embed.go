@@ -0,0 +1,139 @@
+package face
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+const (
+	embedSide      = 64
+	embedGridSide  = 4
+	embedCellSide  = embedSide / embedGridSide
+	lbpUniformBins = 59
+)
+
+// Embedding is a face descriptor built from uniform local binary patterns
+// histograms. Embeddings are L2 normalized, so their dot product is a cosine
+// similarity.
+type Embedding []float32
+
+var lbpUniform = func() [256]uint8 {
+	var m [256]uint8
+	next := uint8(0)
+	for c := 0; c < 256; c++ {
+		transitions := 0
+		for b := uint(0); b < 8; b++ {
+			if (c>>b)&1 != (c>>((b+1)%8))&1 {
+				transitions++
+			}
+		}
+		if transitions <= 2 {
+			m[c] = next
+			next++
+		} else {
+			m[c] = lbpUniformBins - 1
+		}
+	}
+	return m
+}()
+
+// grayFace crops the face box from the image, scales it to the embedding
+// side and equalizes its histogram.
+func grayFace(img image.Image, box image.Rectangle) []uint8 {
+	g := make([]uint8, embedSide*embedSide)
+
+	var hist [256]int
+
+	for y := 0; y < embedSide; y++ {
+		for x := 0; x < embedSide; x++ {
+			sx := box.Min.X + (2*x+1)*box.Dx()/(2*embedSide)
+			sy := box.Min.Y + (2*y+1)*box.Dy()/(2*embedSide)
+			v := color.GrayModel.Convert(img.At(sx, sy)).(color.Gray).Y
+			g[y*embedSide+x] = v
+			hist[v]++
+		}
+	}
+
+	var (
+		cdf [256]int
+		sum int
+	)
+
+	for i, c := range hist {
+		sum += c
+		cdf[i] = sum
+	}
+
+	cdfMin := 0
+	for _, c := range cdf {
+		if c > 0 {
+			cdfMin = c
+			break
+		}
+	}
+
+	if sum == cdfMin {
+		return g
+	}
+
+	for i, v := range g {
+		g[i] = uint8((cdf[v] - cdfMin) * 255 / (sum - cdfMin))
+	}
+
+	return g
+}
+
+func Embed(img image.Image, box image.Rectangle) Embedding {
+	g := grayFace(img, box)
+
+	e := make(Embedding, embedGridSide*embedGridSide*lbpUniformBins)
+
+	for y := 1; y < embedSide-1; y++ {
+		for x := 1; x < embedSide-1; x++ {
+			c := g[y*embedSide+x]
+
+			var code uint8
+			for i, n := range [8][2]int{
+				{-1, -1}, {0, -1}, {1, -1}, {1, 0},
+				{1, 1}, {0, 1}, {-1, 1}, {-1, 0},
+			} {
+				if g[(y+n[1])*embedSide+x+n[0]] >= c {
+					code |= 1 << uint(i)
+				}
+			}
+
+			cell := (y/embedCellSide)*embedGridSide + x/embedCellSide
+			e[cell*lbpUniformBins+int(lbpUniform[code])]++
+		}
+	}
+
+	var norm float64
+
+	for i, v := range e {
+		e[i] = float32(math.Sqrt(float64(v)))
+		norm += float64(v)
+	}
+
+	norm = math.Sqrt(norm)
+
+	for i := range e {
+		e[i] /= float32(norm)
+	}
+
+	return e
+}
+
+func Similarity(a, b Embedding) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+
+	var s float64
+
+	for i := range a {
+		s += float64(a[i]) * float64(b[i])
+	}
+
+	return s
+}
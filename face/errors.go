@@ -0,0 +1,101 @@
+package face
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+type ErrorCategory string
+
+const (
+	CategoryUnknown        ErrorCategory = "unknown"
+	CategoryFaceNotFound   ErrorCategory = "face_not_found"
+	CategoryUserNotFound   ErrorCategory = "user_not_found"
+	CategoryPhotoNotFound  ErrorCategory = "photo_not_found"
+	CategoryInvalidImage   ErrorCategory = "invalid_image"
+	CategoryInvalidRequest ErrorCategory = "invalid_request"
+	CategoryQuotaExceeded  ErrorCategory = "quota_exceeded"
+	CategoryUnauthorized   ErrorCategory = "unauthorized"
+	CategoryUnavailable    ErrorCategory = "unavailable"
+)
+
+// Error is a face backend error. Errors are matched by errors.Is with
+// category sentinels such as ErrFaceNotFound or ErrQuotaExceeded.
+type Error struct {
+	Category   ErrorCategory
+	StatusCode int
+	Message    string
+	Err        error
+}
+
+func (e *Error) Error() string {
+	msg := string(e.Category)
+	if e.StatusCode != 0 {
+		msg += fmt.Sprintf(" (status %d)", e.StatusCode)
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Category == e.Category
+}
+
+var (
+	ErrFaceNotFound   = &Error{Category: CategoryFaceNotFound}
+	ErrUserNotFound   = &Error{Category: CategoryUserNotFound}
+	ErrPhotoNotFound  = &Error{Category: CategoryPhotoNotFound}
+	ErrInvalidImage   = &Error{Category: CategoryInvalidImage}
+	ErrInvalidRequest = &Error{Category: CategoryInvalidRequest}
+	ErrQuotaExceeded  = &Error{Category: CategoryQuotaExceeded}
+	ErrUnauthorized   = &Error{Category: CategoryUnauthorized}
+	ErrUnavailable    = &Error{Category: CategoryUnavailable}
+)
+
+// Category returns category of the error or CategoryUnknown if error is not
+// face backend error.
+func Category(err error) ErrorCategory {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Category
+	}
+	return CategoryUnknown
+}
+
+// categoryByResponse maps provider response to error category.
+func categoryByResponse(statusCode int, code string) ErrorCategory {
+	switch c := ErrorCategory(code); c {
+	case CategoryFaceNotFound, CategoryUserNotFound, CategoryPhotoNotFound, CategoryInvalidImage,
+		CategoryInvalidRequest, CategoryQuotaExceeded, CategoryUnauthorized,
+		CategoryUnavailable:
+		return c
+	}
+
+	switch {
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		return CategoryUnauthorized
+	case statusCode == http.StatusTooManyRequests || statusCode == http.StatusPaymentRequired:
+		return CategoryQuotaExceeded
+	case statusCode == http.StatusNotFound:
+		return CategoryUserNotFound
+	case statusCode == http.StatusRequestEntityTooLarge || statusCode == http.StatusUnsupportedMediaType:
+		return CategoryInvalidImage
+	case statusCode >= 400 && statusCode < 500:
+		return CategoryInvalidRequest
+	case statusCode >= 500:
+		return CategoryUnavailable
+	}
+
+	return CategoryUnknown
+}
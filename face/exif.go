@@ -0,0 +1,144 @@
+package face
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+)
+
+const exifOrientationTag = 0x0112
+
+// exifOrientation returns EXIF orientation of JPEG image or 1 if image has no
+// orientation. Error is returned if JPEG segment length is malformed.
+func exifOrientation(data []byte) (int, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1, nil
+	}
+
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1, nil
+		}
+
+		marker := data[i+1]
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+
+		// Start of scan, there is no metadata after it.
+		if marker == 0xDA {
+			return 1, nil
+		}
+
+		// Length includes its own two bytes.
+		if length < 2 {
+			return 0, fmt.Errorf("invalid length %d of JPEG segment 0x%X at offset %d", length, marker, i)
+		}
+
+		segment := data[i+4:]
+		if length-2 < len(segment) {
+			segment = segment[:length-2]
+		}
+
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return tiffOrientation(segment[6:]), nil
+		}
+
+		i += 2 + length
+	}
+
+	return 1, nil
+}
+
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var bo binary.ByteOrder
+
+	switch string(tiff[:2]) {
+	case "II":
+		bo = binary.LittleEndian
+	case "MM":
+		bo = binary.BigEndian
+	default:
+		return 1
+	}
+
+	// Offset is checked before conversion, so it doesn't overflow int on 32
+	// bit platforms.
+	ifdOffset := bo.Uint32(tiff[4:])
+	if ifdOffset > uint32(len(tiff)-2) {
+		return 1
+	}
+
+	ifd := int(ifdOffset)
+
+	entries := int(bo.Uint16(tiff[ifd:]))
+
+	for e := 0; e < entries; e++ {
+		off := ifd + 2 + e*12
+		if off+12 > len(tiff) {
+			return 1
+		}
+		if bo.Uint16(tiff[off:]) == exifOrientationTag {
+			o := int(bo.Uint16(tiff[off+8:]))
+			if o < 1 || o > 8 {
+				return 1
+			}
+			return o
+		}
+	}
+
+	return 1
+}
+
+// orient transforms image stored with given EXIF orientation to normal
+// orientation.
+func orient(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	// Orientations from 5 to 8 transpose image.
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+
+	src := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			i := src.PixOffset(x, y)
+			j := dst.PixOffset(dx, dy)
+			copy(dst.Pix[j:j+4], src.Pix[i:i+4])
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,121 @@
+package face
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// exifSegment returns APP1 segment with TIFF header of given byte order and
+// single IFD entry with given tag and value.
+func exifSegment(bo binary.ByteOrder, tag, value uint16) []byte {
+	tiff := make([]byte, 8+2+12)
+
+	if bo == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+
+	bo.PutUint16(tiff[2:], 42)
+	bo.PutUint32(tiff[4:], 8)
+	bo.PutUint16(tiff[8:], 1)
+	bo.PutUint16(tiff[10:], tag)
+	bo.PutUint16(tiff[12:], 3)
+	bo.PutUint32(tiff[14:], 1)
+	bo.PutUint16(tiff[18:], value)
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+
+	return segment(0xE1, payload)
+}
+
+func segment(marker byte, payload []byte) []byte {
+	s := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(s[2:], uint16(len(payload)+2))
+	return append(s, payload...)
+}
+
+func jpegData(segments ...[]byte) []byte {
+	data := []byte{0xFF, 0xD8}
+	for _, s := range segments {
+		data = append(data, s...)
+	}
+	return data
+}
+
+func TestExifOrientation(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		want    int
+		wantErr bool
+	}{
+		{
+			name: "not JPEG",
+			data: []byte("PNG image"),
+			want: 1,
+		},
+		{
+			name: "no EXIF",
+			data: jpegData(segment(0xE0, []byte("JFIF\x00")), segment(0xDA, nil)),
+			want: 1,
+		},
+		{
+			name: "little endian",
+			data: jpegData(exifSegment(binary.LittleEndian, exifOrientationTag, 6)),
+			want: 6,
+		},
+		{
+			name: "big endian after other segment",
+			data: jpegData(segment(0xE0, []byte("JFIF\x00")), exifSegment(binary.BigEndian, exifOrientationTag, 8)),
+			want: 8,
+		},
+		{
+			name: "no orientation tag",
+			data: jpegData(exifSegment(binary.LittleEndian, 0x0100, 6)),
+			want: 1,
+		},
+		{
+			name: "invalid orientation",
+			data: jpegData(exifSegment(binary.BigEndian, exifOrientationTag, 9)),
+			want: 1,
+		},
+		{
+			name: "EXIF after start of scan",
+			data: jpegData(segment(0xDA, nil), exifSegment(binary.BigEndian, exifOrientationTag, 3)),
+			want: 1,
+		},
+		{
+			name: "truncated EXIF",
+			data: jpegData(exifSegment(binary.BigEndian, exifOrientationTag, 3))[:20],
+			want: 1,
+		},
+		{
+			name: "IFD offset out of range",
+			data: func() []byte {
+				s := exifSegment(binary.BigEndian, exifOrientationTag, 3)
+				// TIFF header follows segment header and EXIF identifier.
+				binary.BigEndian.PutUint32(s[4+6+4:], 0xFFFFFFF0)
+				return jpegData(s)
+			}(),
+			want: 1,
+		},
+		{
+			name:    "invalid segment length",
+			data:    []byte{0xFF, 0xD8, 0xFF, 0xE0, 0x00, 0x01, 0x00, 0x00},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := exifOrientation(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("orientation = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package face
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"sort"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+)
+
+type Candidate struct {
+	UserID string  `json:"user_id"`
+	Score  float64 `json:"score"`
+}
+
+// Recognition is a result of face recognition. Candidates are ordered by
+// score, best first.
+type Recognition struct {
+	Box        image.Rectangle `json:"box"`
+	Candidates []Candidate     `json:"candidates"`
+}
+
+func (r Recognition) Best() (Candidate, bool) {
+	if len(r.Candidates) == 0 {
+		return Candidate{}, false
+	}
+	return r.Candidates[0], true
+}
+
+func (r Recognition) Score(userID string) float64 {
+	for _, c := range r.Candidates {
+		if c.UserID == userID {
+			return c.Score
+		}
+	}
+	return 0
+}
+
+func sortCandidates(cs []Candidate) {
+	sort.SliceStable(cs, func(i, j int) bool {
+		return cs[i].Score > cs[j].Score
+	})
+}
+
+func sortRecognitions(rs []Recognition) {
+	sort.SliceStable(rs, func(i, j int) bool {
+		return area(rs[i].Box) > area(rs[j].Box)
+	})
+}
+
+type Recognizer interface {
+	RecognizeUser(ctx context.Context, photo io.Reader) (Recognition, error)
+}
+
+// MultiRecognizer recognizes every face on the photo. Recognitions are
+// ordered by face area, largest first.
+type MultiRecognizer interface {
+	RecognizeUsers(ctx context.Context, photo io.Reader) ([]Recognition, error)
+}
+
+// RecognizeUsers recognizes every face on the photo if recognizer supports
+// it, otherwise only the main face is recognized.
+func RecognizeUsers(ctx context.Context, r Recognizer, photo io.Reader) ([]Recognition, error) {
+	if mr, ok := r.(MultiRecognizer); ok {
+		rs, err := mr.RecognizeUsers(ctx, photo)
+		if err != nil {
+			return nil, err
+		}
+		if len(rs) == 0 {
+			return nil, ErrFaceNotFound
+		}
+		return rs, nil
+	}
+
+	rec, err := r.RecognizeUser(ctx, photo)
+	if err != nil {
+		return nil, err
+	}
+
+	return []Recognition{rec}, nil
+}
+
+// Enroller manages enrolled users. AddUserPhoto returns ID of the added photo
+// which can be used to remove it later.
+type Enroller interface {
+	AddUser(ctx context.Context, photoFilePath string) (string, error)
+	AddUserPhoto(ctx context.Context, userID string, photoFilePath string) (string, error)
+	RemoveUserPhoto(ctx context.Context, userID string, photoID string) error
+	RemoveUser(ctx context.Context, userID string) error
+}
+
+type Backend interface {
+	Recognizer
+	Enroller
+}
+
+// Close releases resources of the backend if it holds any.
+func Close(b interface{}) error {
+	if c, ok := b.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Factory creates backend. Decode unmarshals backend specific parameters
+// from the face API config into the given value.
+type Factory func(decode func(interface{}) error) (Backend, error)
+
+var (
+	factories   = map[string]Factory{}
+	factoriesMx sync.RWMutex
+)
+
+func Register(name string, f Factory) {
+	factoriesMx.Lock()
+	defer factoriesMx.Unlock()
+
+	if _, exists := factories[name]; exists {
+		panic(fmt.Sprintf("face backend %s already registered", name))
+	}
+
+	factories[name] = f
+}
+
+func Backends() []string {
+	factoriesMx.RLock()
+	defer factoriesMx.RUnlock()
+
+	var names []string
+
+	for name := range factories {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+const DefaultBackend = "api"
+
+type Config struct {
+	Backend    string                 `yaml:"backend"`
+	Resilience ResilienceConfig       `yaml:"resilience"`
+	Cache      CacheConfig            `yaml:"cache"`
+	Params     map[string]interface{} `yaml:",inline"`
+}
+
+func New(c Config) (Backend, error) {
+	name := c.Backend
+	if name == "" {
+		name = DefaultBackend
+	}
+
+	factoriesMx.RLock()
+	f, exists := factories[name]
+	factoriesMx.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("unknown face backend %s", name)
+	}
+
+	b, err := f(func(v interface{}) error {
+		params, err := yaml.Marshal(c.Params)
+		if err != nil {
+			return fmt.Errorf("YAML encode params: %w", err)
+		}
+		return yaml.Unmarshal(params, v)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create %s face backend: %w", name, err)
+	}
+
+	r, err := NewResilient(b, c.Resilience)
+	if err != nil {
+		return nil, fmt.Errorf("create resilient face backend: %w", err)
+	}
+
+	if c.Cache.IndexFilePath == "" {
+		return r, nil
+	}
+
+	cb, err := NewCached(r, c.Cache)
+	if err != nil {
+		return nil, fmt.Errorf("create cached face backend: %w", err)
+	}
+
+	return cb, nil
+}
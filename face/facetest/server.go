@@ -0,0 +1,427 @@
+// Package facetest provides in-process fake face API server for integration
+// testing of face.API users.
+package facetest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dimuls/oko/face"
+)
+
+// Fingerprint returns deterministic photo fingerprint by which server
+// identifies faces.
+func Fingerprint(photo []byte) string {
+	h := sha256.Sum256(photo)
+	return hex.EncodeToString(h[:])
+}
+
+type Server struct {
+	server *httptest.Server
+
+	mx            sync.Mutex
+	users         map[string][]string
+	fingerprints  map[string]string
+	faceless      map[string]bool
+	candidates    map[string][]face.Candidate
+	faces         map[string][]face.Recognition
+	nextID        int
+	latency       time.Duration
+	faceNotFound  bool
+	failureStatus int
+	requests      int
+}
+
+func NewServer() *Server {
+	s := &Server{
+		users:        map[string][]string{},
+		fingerprints: map[string]string{},
+		faceless:     map[string]bool{},
+		candidates:   map[string][]face.Candidate{},
+		faces:        map[string][]face.Recognition{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users", s.handleAddUser)
+	mux.HandleFunc("/users/", s.handleUsers)
+	mux.HandleFunc("/recognize", s.handleRecognize)
+	mux.HandleFunc("/recognize_all", s.handleRecognizeAll)
+
+	s.server = httptest.NewServer(s.middleware(mux))
+
+	return s
+}
+
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+func (s *Server) URL() string {
+	return s.server.URL
+}
+
+// Config returns face.API config pointing to server.
+func (s *Server) Config() face.APIConfig {
+	return face.APIConfig{URL: s.server.URL}
+}
+
+// SetLatency sets delay added to every request.
+func (s *Server) SetLatency(d time.Duration) {
+	s.mx.Lock()
+	s.latency = d
+	s.mx.Unlock()
+}
+
+// SetFaceNotFound makes server report that there is no face on any photo.
+func (s *Server) SetFaceNotFound(faceNotFound bool) {
+	s.mx.Lock()
+	s.faceNotFound = faceNotFound
+	s.mx.Unlock()
+}
+
+// SetFailure makes server respond to every request with given status code.
+// Zero status code disables failures.
+func (s *Server) SetFailure(statusCode int) {
+	s.mx.Lock()
+	s.failureStatus = statusCode
+	s.mx.Unlock()
+}
+
+// MarkFaceless makes server report that there is no face on the photo.
+func (s *Server) MarkFaceless(photo []byte) {
+	s.mx.Lock()
+	s.faceless[Fingerprint(photo)] = true
+	s.mx.Unlock()
+}
+
+// SetCandidates makes server respond with given candidates when the photo is
+// recognized. By default enrolled photo is recognized as its user with score
+// 1 and unknown photo is recognized without candidates.
+func (s *Server) SetCandidates(photo []byte, cs ...face.Candidate) {
+	s.mx.Lock()
+	s.candidates[Fingerprint(photo)] = cs
+	s.mx.Unlock()
+}
+
+// SetFaces makes server respond with given faces when every face on the
+// photo is recognized. The first face is also used when only the main face is
+// recognized.
+func (s *Server) SetFaces(photo []byte, rs ...face.Recognition) {
+	s.mx.Lock()
+	s.faces[Fingerprint(photo)] = rs
+	s.mx.Unlock()
+}
+
+// Enroll adds photo to the user creating user if necessary.
+func (s *Server) Enroll(userID string, photo []byte) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	fp := Fingerprint(photo)
+	s.users[userID] = append(s.users[userID], fp)
+	s.fingerprints[fp] = userID
+}
+
+// Users returns enrolled users with their photos fingerprints.
+func (s *Server) Users() map[string][]string {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	us := map[string][]string{}
+
+	for id, fps := range s.users {
+		us[id] = append([]string(nil), fps...)
+	}
+
+	return us
+}
+
+// Requests returns number of requests server received.
+func (s *Server) Requests() int {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return s.requests
+}
+
+type recognizeResponse struct {
+	Box struct {
+		X      int `json:"x"`
+		Y      int `json:"y"`
+		Width  int `json:"width"`
+		Height int `json:"height"`
+	} `json:"box"`
+	Candidates []face.Candidate `json:"candidates"`
+}
+
+func newRecognizeResponse(r face.Recognition) recognizeResponse {
+	var res recognizeResponse
+	res.Box.X = r.Box.Min.X
+	res.Box.Y = r.Box.Min.Y
+	res.Box.Width = r.Box.Dx()
+	res.Box.Height = r.Box.Dy()
+	res.Candidates = r.Candidates
+	return res
+}
+
+type recognizeAllResponse struct {
+	Faces []recognizeResponse `json:"faces"`
+}
+
+type errorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, statusCode int, code, message string) {
+	writeJSON(w, statusCode, errorResponse{Code: code, Message: message})
+}
+
+func (s *Server) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mx.Lock()
+		s.requests++
+		latency := s.latency
+		failureStatus := s.failureStatus
+		s.mx.Unlock()
+
+		if latency > 0 {
+			select {
+			case <-time.After(latency):
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		if failureStatus != 0 {
+			writeError(w, failureStatus, "injected_failure", http.StatusText(failureStatus))
+			return
+		}
+
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", r.Method)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// readPhoto reads photo from multipart form and returns its fingerprint.
+func (s *Server) readPhoto(w http.ResponseWriter, r *http.Request) (string, bool) {
+	f, _, err := r.FormFile("photo")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_image", fmt.Sprintf("read photo: %v", err))
+		return "", false
+	}
+	defer f.Close()
+
+	photo, err := ioutil.ReadAll(f)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_image", fmt.Sprintf("read photo: %v", err))
+		return "", false
+	}
+
+	fp := Fingerprint(photo)
+
+	if s.faceNotFound || s.faceless[fp] {
+		writeError(w, http.StatusUnprocessableEntity, "face_not_found", "face not found")
+		return "", false
+	}
+
+	return fp, true
+}
+
+func (s *Server) handleAddUser(w http.ResponseWriter, r *http.Request) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	fp, ok := s.readPhoto(w, r)
+	if !ok {
+		return
+	}
+
+	s.nextID++
+	userID := fmt.Sprintf("user-%d", s.nextID)
+
+	s.users[userID] = []string{fp}
+	s.fingerprints[fp] = userID
+
+	writeJSON(w, http.StatusOK, map[string]string{"user_id": userID})
+}
+
+func (s *Server) handleUsers(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/users/remove" {
+		s.handleRemoveUser(w, r)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/users/"), "/")
+
+	switch {
+	case len(parts) == 2 && parts[1] == "photos":
+		s.handleAddUserPhoto(w, r, parts[0])
+	case len(parts) == 3 && parts[1] == "photos" && parts[2] == "remove":
+		s.handleRemoveUserPhoto(w, r, parts[0])
+	default:
+		writeError(w, http.StatusNotFound, "not_found", r.URL.Path)
+	}
+}
+
+func (s *Server) handleAddUserPhoto(w http.ResponseWriter, r *http.Request, userID string) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if _, exists := s.users[userID]; !exists {
+		writeError(w, http.StatusNotFound, "user_not_found", userID)
+		return
+	}
+
+	fp, ok := s.readPhoto(w, r)
+	if !ok {
+		return
+	}
+
+	s.users[userID] = append(s.users[userID], fp)
+	s.fingerprints[fp] = userID
+
+	writeJSON(w, http.StatusOK, map[string]string{"photo_id": fp})
+}
+
+func (s *Server) handleRemoveUserPhoto(w http.ResponseWriter, r *http.Request, userID string) {
+	var req struct {
+		PhotoID string `json:"photo_id"`
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	fps, exists := s.users[userID]
+	if !exists {
+		writeError(w, http.StatusNotFound, "user_not_found", userID)
+		return
+	}
+
+	removed := false
+
+	for i, fp := range fps {
+		if fp == req.PhotoID {
+			s.users[userID] = append(fps[:i:i], fps[i+1:]...)
+			delete(s.fingerprints, fp)
+			removed = true
+			break
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]bool{"removed": removed})
+}
+
+// recognize reads photo from request body and returns recognized faces.
+func (s *Server) recognize(w http.ResponseWriter, r *http.Request) ([]face.Recognition, bool) {
+	photo, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_image", fmt.Sprintf("read photo: %v", err))
+		return nil, false
+	}
+
+	if len(photo) == 0 {
+		writeError(w, http.StatusBadRequest, "invalid_image", "empty photo")
+		return nil, false
+	}
+
+	fp := Fingerprint(photo)
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if s.faceNotFound || s.faceless[fp] {
+		writeError(w, http.StatusUnprocessableEntity, "face_not_found", "face not found")
+		return nil, false
+	}
+
+	if rs, exists := s.faces[fp]; exists {
+		return rs, true
+	}
+
+	cs, exists := s.candidates[fp]
+	if !exists {
+		if userID, enrolled := s.fingerprints[fp]; enrolled {
+			cs = []face.Candidate{{UserID: userID, Score: 1}}
+		}
+	}
+
+	return []face.Recognition{{Candidates: cs}}, true
+}
+
+func (s *Server) handleRecognize(w http.ResponseWriter, r *http.Request) {
+	rs, ok := s.recognize(w, r)
+	if !ok {
+		return
+	}
+
+	if len(rs) == 0 {
+		writeError(w, http.StatusUnprocessableEntity, "face_not_found", "face not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newRecognizeResponse(rs[0]))
+}
+
+func (s *Server) handleRecognizeAll(w http.ResponseWriter, r *http.Request) {
+	rs, ok := s.recognize(w, r)
+	if !ok {
+		return
+	}
+
+	var res recognizeAllResponse
+
+	for _, rec := range rs {
+		res.Faces = append(res.Faces, newRecognizeResponse(rec))
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (s *Server) handleRemoveUser(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		UserID string `json:"user_id"`
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	fps, exists := s.users[req.UserID]
+	if exists {
+		for _, fp := range fps {
+			delete(s.fingerprints, fp)
+		}
+		delete(s.users, req.UserID)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]bool{"removed": exists})
+}
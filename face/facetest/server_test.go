@@ -0,0 +1,267 @@
+package facetest_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dimuls/oko/face"
+	"github.com/dimuls/oko/face/facetest"
+)
+
+func newAPI(t *testing.T, s *facetest.Server) *face.API {
+	api, err := face.NewAPI(s.Config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return api
+}
+
+func TestRecognizeUser(t *testing.T) {
+	photo := []byte("photo")
+
+	tests := []struct {
+		name       string
+		setup      func(s *facetest.Server)
+		wantUserID string
+		wantScore  float64
+		wantErr    bool
+		wantErrIs  error
+	}{
+		{
+			name: "enrolled",
+			setup: func(s *facetest.Server) {
+				s.Enroll("user-1", photo)
+			},
+			wantUserID: "user-1",
+			wantScore:  1,
+		},
+		{
+			name: "candidates best first",
+			setup: func(s *facetest.Server) {
+				s.SetCandidates(photo,
+					face.Candidate{UserID: "user-2", Score: 0.4},
+					face.Candidate{UserID: "user-1", Score: 0.7})
+			},
+			wantUserID: "user-1",
+			wantScore:  0.7,
+		},
+		{
+			name: "unknown",
+			setup: func(s *facetest.Server) {
+				s.Enroll("user-1", []byte("other photo"))
+			},
+		},
+		{
+			name: "faceless photo",
+			setup: func(s *facetest.Server) {
+				s.Enroll("user-1", photo)
+				s.MarkFaceless(photo)
+			},
+			wantErr:   true,
+			wantErrIs: face.ErrFaceNotFound,
+		},
+		{
+			name: "face not found",
+			setup: func(s *facetest.Server) {
+				s.Enroll("user-1", photo)
+				s.SetFaceNotFound(true)
+			},
+			wantErr:   true,
+			wantErrIs: face.ErrFaceNotFound,
+		},
+		{
+			name: "failure",
+			setup: func(s *facetest.Server) {
+				s.Enroll("user-1", photo)
+				s.SetFailure(http.StatusServiceUnavailable)
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := facetest.NewServer()
+			defer s.Close()
+
+			tt.setup(s)
+
+			r, err := newAPI(t, s).RecognizeUser(context.Background(), bytes.NewReader(photo))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErrIs != nil && !errors.Is(err, tt.wantErrIs) {
+				t.Errorf("error = %v, want %v", err, tt.wantErrIs)
+			}
+			best, _ := r.Best()
+			if best.UserID != tt.wantUserID || best.Score != tt.wantScore {
+				t.Errorf("best candidate = %+v, want %s with score %.2f", best, tt.wantUserID, tt.wantScore)
+			}
+			if s.Requests() != 1 {
+				t.Errorf("requests = %d, want 1", s.Requests())
+			}
+		})
+	}
+}
+
+func TestRecognizeUsers(t *testing.T) {
+	photo := []byte("photo")
+
+	small := face.Recognition{
+		Box:        image.Rect(0, 0, 50, 50),
+		Candidates: []face.Candidate{{UserID: "user-2", Score: 0.9}},
+	}
+
+	large := face.Recognition{
+		Box:        image.Rect(50, 0, 150, 100),
+		Candidates: []face.Candidate{{UserID: "user-1", Score: 0.95}},
+	}
+
+	tests := []struct {
+		name        string
+		setup       func(s *facetest.Server)
+		wantUserIDs []string
+		wantErrIs   error
+	}{
+		{
+			name: "single face",
+			setup: func(s *facetest.Server) {
+				s.Enroll("user-1", photo)
+			},
+			wantUserIDs: []string{"user-1"},
+		},
+		{
+			name: "largest face first",
+			setup: func(s *facetest.Server) {
+				s.SetFaces(photo, small, large)
+			},
+			wantUserIDs: []string{"user-1", "user-2"},
+		},
+		{
+			name: "faceless photo",
+			setup: func(s *facetest.Server) {
+				s.MarkFaceless(photo)
+			},
+			wantErrIs: face.ErrFaceNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := facetest.NewServer()
+			defer s.Close()
+
+			tt.setup(s)
+
+			rs, err := face.RecognizeUsers(context.Background(), newAPI(t, s), bytes.NewReader(photo))
+			if !errors.Is(err, tt.wantErrIs) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErrIs)
+			}
+
+			var userIDs []string
+			for _, r := range rs {
+				best, _ := r.Best()
+				userIDs = append(userIDs, best.UserID)
+			}
+
+			if len(userIDs) != len(tt.wantUserIDs) {
+				t.Fatalf("recognized users = %v, want %v", userIDs, tt.wantUserIDs)
+			}
+			for i := range userIDs {
+				if userIDs[i] != tt.wantUserIDs[i] {
+					t.Errorf("recognized users = %v, want %v", userIDs, tt.wantUserIDs)
+				}
+			}
+		})
+	}
+}
+
+func writeFile(t *testing.T, dirPath, name string, data []byte) string {
+	filePath := filepath.Join(dirPath, name)
+	err := ioutil.WriteFile(filePath, data, 0664)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filePath
+}
+
+func TestEnrollment(t *testing.T) {
+	dirPath, err := ioutil.TempDir("", "facetest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dirPath)
+
+	s := facetest.NewServer()
+	defer s.Close()
+
+	api := newAPI(t, s)
+	ctx := context.Background()
+
+	first := writeFile(t, dirPath, "first.jpg", []byte("first"))
+	second := writeFile(t, dirPath, "second.jpg", []byte("second"))
+
+	userID, err := api.AddUser(ctx, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userID != "user-1" {
+		t.Errorf("user ID = %q, want user-1", userID)
+	}
+
+	photoID, err := api.AddUserPhoto(ctx, userID, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := len(s.Users()[userID]); got != 2 {
+		t.Errorf("user photos = %d, want 2", got)
+	}
+
+	_, err = api.AddUserPhoto(ctx, "user-2", second)
+	if err == nil {
+		t.Error("photo is added to not existing user")
+	}
+
+	r, err := api.RecognizeUser(ctx, bytes.NewReader([]byte("second")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if best, _ := r.Best(); best.UserID != userID {
+		t.Errorf("recognized user ID = %q, want %q", best.UserID, userID)
+	}
+
+	err = api.RemoveUserPhoto(ctx, userID, photoID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err = api.RecognizeUser(ctx, bytes.NewReader([]byte("second")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if best, ok := r.Best(); ok {
+		t.Errorf("removed photo is recognized as %q", best.UserID)
+	}
+
+	err = api.RemoveUser(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(s.Users()) != 0 {
+		t.Errorf("users = %v, want none", s.Users())
+	}
+
+	err = api.RemoveUser(ctx, userID)
+	if !errors.Is(err, face.ErrUserNotFound) {
+		t.Errorf("error = %v, want %v", err, face.ErrUserNotFound)
+	}
+}
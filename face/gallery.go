@@ -0,0 +1,212 @@
+package face
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const galleryFileExt = ".json"
+
+type galleryPhoto struct {
+	ID        string    `json:"id"`
+	Embedding Embedding `json:"embedding"`
+}
+
+type galleryUser struct {
+	ID     string         `json:"id"`
+	Photos []galleryPhoto `json:"photos"`
+}
+
+// gallery stores enrolled users embeddings in directory, one JSON file per
+// user.
+type gallery struct {
+	dirPath string
+	users   map[string]galleryUser
+	mx      sync.RWMutex
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func openGallery(dirPath string) (*gallery, error) {
+	err := os.MkdirAll(dirPath, 0775)
+	if err != nil {
+		return nil, fmt.Errorf("create gallery directory: %w", err)
+	}
+
+	fis, err := ioutil.ReadDir(dirPath)
+	if err != nil {
+		return nil, fmt.Errorf("read gallery directory: %w", err)
+	}
+
+	g := &gallery{
+		dirPath: dirPath,
+		users:   map[string]galleryUser{},
+	}
+
+	for _, fi := range fis {
+		if fi.IsDir() || filepath.Ext(fi.Name()) != galleryFileExt {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(dirPath, fi.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("read gallery file %s: %w", fi.Name(), err)
+		}
+
+		var u galleryUser
+
+		err = json.Unmarshal(data, &u)
+		if err != nil {
+			return nil, fmt.Errorf("JSON decode gallery file %s: %w", fi.Name(), err)
+		}
+
+		g.users[u.ID] = u
+	}
+
+	return g, nil
+}
+
+func (g *gallery) userFilePath(userID string) string {
+	return filepath.Join(g.dirPath, userID+galleryFileExt)
+}
+
+func (g *gallery) save(u galleryUser) error {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return fmt.Errorf("JSON encode user: %w", err)
+	}
+
+	tmpFilePath := g.userFilePath(u.ID) + ".tmp"
+
+	err = ioutil.WriteFile(tmpFilePath, data, 0664)
+	if err != nil {
+		return fmt.Errorf("write user file: %w", err)
+	}
+
+	err = os.Rename(tmpFilePath, g.userFilePath(u.ID))
+	if err != nil {
+		return fmt.Errorf("rename user file: %w", err)
+	}
+
+	return nil
+}
+
+func (g *gallery) addPhoto(userID string, e Embedding) (string, error) {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+
+	photoID, err := newID()
+	if err != nil {
+		return "", fmt.Errorf("generate photo ID: %w", err)
+	}
+
+	u, exists := g.users[userID]
+	if !exists {
+		u.ID = userID
+	}
+
+	u.Photos = append(u.Photos, galleryPhoto{ID: photoID, Embedding: e})
+
+	err = g.save(u)
+	if err != nil {
+		return "", err
+	}
+
+	g.users[userID] = u
+
+	return photoID, nil
+}
+
+func (g *gallery) removePhoto(userID, photoID string) error {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+
+	u, exists := g.users[userID]
+	if !exists {
+		return ErrUserNotFound
+	}
+
+	var photos []galleryPhoto
+
+	for _, p := range u.Photos {
+		if p.ID != photoID {
+			photos = append(photos, p)
+		}
+	}
+
+	if len(photos) == len(u.Photos) {
+		return ErrPhotoNotFound
+	}
+
+	u.Photos = photos
+
+	err := g.save(u)
+	if err != nil {
+		return err
+	}
+
+	g.users[userID] = u
+
+	return nil
+}
+
+func (g *gallery) exists(userID string) bool {
+	g.mx.RLock()
+	defer g.mx.RUnlock()
+
+	_, exists := g.users[userID]
+	return exists
+}
+
+func (g *gallery) removeUser(userID string) error {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+
+	if _, exists := g.users[userID]; !exists {
+		return ErrUserNotFound
+	}
+
+	err := os.Remove(g.userFilePath(userID))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove user file: %w", err)
+	}
+
+	delete(g.users, userID)
+
+	return nil
+}
+
+// search returns the best score of every user ordered by score, best first.
+func (g *gallery) search(e Embedding) []Candidate {
+	g.mx.RLock()
+	defer g.mx.RUnlock()
+
+	var cs []Candidate
+
+	for _, u := range g.users {
+		best := 0.
+		for _, p := range u.Photos {
+			if s := Similarity(e, p.Embedding); s > best {
+				best = s
+			}
+		}
+		cs = append(cs, Candidate{UserID: u.ID, Score: best})
+	}
+
+	sortCandidates(cs)
+
+	return cs
+}
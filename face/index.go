@@ -0,0 +1,395 @@
+package face
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	defaultIndexTables = 8
+	defaultIndexBits   = 12
+)
+
+type indexEntry struct {
+	UserID    string    `json:"user_id"`
+	Embedding Embedding `json:"embedding"`
+	AddedAt   time.Time `json:"added_at"`
+	Enrolled  bool      `json:"enrolled"`
+	PhotoID   string    `json:"photo_id,omitempty"`
+
+	keys []uint64
+}
+
+type indexFile struct {
+	Seed    int64         `json:"seed"`
+	Tables  int           `json:"tables"`
+	Bits    int           `json:"bits"`
+	Entries []*indexEntry `json:"entries"`
+}
+
+// index is an approximate nearest neighbour index of embeddings based on
+// random hyperplanes locality sensitive hashing. Hyperplanes are generated
+// from the seed, so only seed and embeddings are persisted.
+//
+// Index file could be changed by another process, for example users tool
+// updates index of the running overseer. Index reloads file changed since its
+// last read or write before every search and update, so updates are applied
+// to the actual file content and are not overwritten.
+type index struct {
+	filePath string
+	seed     int64
+	tables   int
+	bits     int
+
+	planes  [][]Embedding
+	offsets [][]float64
+	buckets []map[uint64][]*indexEntry
+	users   map[string][]*indexEntry
+
+	modTime time.Time
+	size    int64
+
+	mx sync.RWMutex
+}
+
+func openIndex(filePath string, tables, bits int) (*index, error) {
+	i := &index{
+		filePath: filePath,
+		tables:   tables,
+		bits:     bits,
+	}
+
+	err := i.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return i, nil
+}
+
+// load reads index file and rebuilds index from it. Missing file gives an
+// empty index.
+func (i *index) load() error {
+	f := indexFile{
+		Seed:   time.Now().UnixNano(),
+		Tables: i.tables,
+		Bits:   i.bits,
+	}
+
+	if i.planes != nil {
+		f.Seed = i.seed
+	}
+
+	fi, err := os.Stat(i.filePath)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return fmt.Errorf("stat index file: %w", err)
+	default:
+		data, err := ioutil.ReadFile(i.filePath)
+		if err != nil {
+			return fmt.Errorf("read index file: %w", err)
+		}
+		var stored indexFile
+		err = json.Unmarshal(data, &stored)
+		if err != nil {
+			return fmt.Errorf("JSON decode index file: %w", err)
+		}
+		f.Entries = stored.Entries
+		// Index parameters change invalidates hashes, not embeddings.
+		if stored.Tables == i.tables && stored.Bits == i.bits {
+			f.Seed = stored.Seed
+		}
+		i.modTime, i.size = fi.ModTime(), fi.Size()
+	}
+
+	if i.planes == nil || f.Seed != i.seed {
+		i.seed = f.Seed
+		i.generatePlanes()
+	}
+
+	i.buckets = make([]map[uint64][]*indexEntry, i.tables)
+	for t := range i.buckets {
+		i.buckets[t] = map[uint64][]*indexEntry{}
+	}
+
+	i.users = map[string][]*indexEntry{}
+
+	for _, e := range f.Entries {
+		i.insert(e)
+	}
+
+	return nil
+}
+
+func (i *index) generatePlanes() {
+	i.planes = make([][]Embedding, i.tables)
+	i.offsets = make([][]float64, i.tables)
+
+	rnd := rand.New(rand.NewSource(i.seed))
+	dim := embedGridSide * embedGridSide * lbpUniformBins
+
+	// Embeddings are non negative, so hyperplanes pass through the center of
+	// the unit vectors with equal components instead of the origin.
+	center := make(Embedding, dim)
+	for d := range center {
+		center[d] = float32(1 / math.Sqrt(float64(dim)))
+	}
+
+	for t := range i.planes {
+		i.planes[t] = make([]Embedding, i.bits)
+		i.offsets[t] = make([]float64, i.bits)
+		for b := range i.planes[t] {
+			p := make(Embedding, dim)
+			for d := range p {
+				p[d] = float32(rnd.NormFloat64())
+			}
+			i.planes[t][b] = p
+			i.offsets[t][b] = Similarity(center, p)
+		}
+	}
+}
+
+// refresh reloads index file if it was changed since the last read or write.
+// Must be called with write lock held.
+func (i *index) refresh() error {
+	fi, err := os.Stat(i.filePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("stat index file: %w", err)
+	}
+
+	if fi.ModTime().Equal(i.modTime) && fi.Size() == i.size {
+		return nil
+	}
+
+	return i.load()
+}
+
+func (i *index) hash(e Embedding) []uint64 {
+	keys := make([]uint64, len(i.planes))
+
+	for t, planes := range i.planes {
+		for b, p := range planes {
+			if Similarity(e, p) > i.offsets[t][b] {
+				keys[t] |= 1 << uint(b)
+			}
+		}
+	}
+
+	return keys
+}
+
+func (i *index) insert(e *indexEntry) {
+	e.keys = i.hash(e.Embedding)
+
+	for t, k := range e.keys {
+		i.buckets[t][k] = append(i.buckets[t][k], e)
+	}
+
+	i.users[e.UserID] = append(i.users[e.UserID], e)
+}
+
+func (i *index) delete(e *indexEntry) {
+	for t, k := range e.keys {
+		b := i.buckets[t][k]
+		for j := range b {
+			if b[j] == e {
+				b = append(b[:j], b[j+1:]...)
+				break
+			}
+		}
+		if len(b) == 0 {
+			delete(i.buckets[t], k)
+		} else {
+			i.buckets[t][k] = b
+		}
+	}
+}
+
+func (i *index) save() error {
+	f := indexFile{
+		Seed:   i.seed,
+		Tables: i.tables,
+		Bits:   i.bits,
+	}
+
+	for _, es := range i.users {
+		f.Entries = append(f.Entries, es...)
+	}
+
+	data, err := json.Marshal(f)
+	if err != nil {
+		return fmt.Errorf("JSON encode index: %w", err)
+	}
+
+	tmpFilePath := i.filePath + ".tmp"
+
+	err = ioutil.WriteFile(tmpFilePath, data, 0664)
+	if err != nil {
+		return fmt.Errorf("write index file: %w", err)
+	}
+
+	err = os.Rename(tmpFilePath, i.filePath)
+	if err != nil {
+		return fmt.Errorf("rename index file: %w", err)
+	}
+
+	fi, err := os.Stat(i.filePath)
+	if err != nil {
+		return fmt.Errorf("stat index file: %w", err)
+	}
+
+	i.modTime, i.size = fi.ModTime(), fi.Size()
+
+	return nil
+}
+
+// add adds user embedding. Learned embedding is not added if user already
+// has embedding at least as similar as dedupScore. The oldest learned user
+// embeddings are evicted above maxPerUser, enrolled ones are never evicted.
+func (i *index) add(userID string, e Embedding, enrolled bool, photoID string, dedupScore float64, maxPerUser int) (bool, error) {
+	i.mx.Lock()
+	defer i.mx.Unlock()
+
+	err := i.refresh()
+	if err != nil {
+		return false, err
+	}
+
+	if !enrolled {
+		for _, ue := range i.users[userID] {
+			if Similarity(e, ue.Embedding) >= dedupScore {
+				return false, nil
+			}
+		}
+	}
+
+	i.insert(&indexEntry{
+		UserID:    userID,
+		Embedding: e,
+		AddedAt:   time.Now(),
+		Enrolled:  enrolled,
+		PhotoID:   photoID,
+	})
+
+	es := i.users[userID]
+	excess := len(es) - maxPerUser
+
+	var kept []*indexEntry
+
+	for _, ue := range es {
+		if excess > 0 && !ue.Enrolled {
+			i.delete(ue)
+			excess--
+			continue
+		}
+		kept = append(kept, ue)
+	}
+
+	i.users[userID] = kept
+
+	return true, i.save()
+}
+
+func (i *index) removeUser(userID string) error {
+	i.mx.Lock()
+	defer i.mx.Unlock()
+
+	err := i.refresh()
+	if err != nil {
+		return err
+	}
+
+	es, exists := i.users[userID]
+	if !exists {
+		return nil
+	}
+
+	for _, e := range es {
+		i.delete(e)
+	}
+
+	delete(i.users, userID)
+
+	return i.save()
+}
+
+// removePhoto removes embedding of the enrolled photo and all learned user
+// embeddings.
+func (i *index) removePhoto(userID, photoID string) error {
+	i.mx.Lock()
+	defer i.mx.Unlock()
+
+	err := i.refresh()
+	if err != nil {
+		return err
+	}
+
+	var kept []*indexEntry
+
+	for _, e := range i.users[userID] {
+		if e.Enrolled && e.PhotoID != photoID {
+			kept = append(kept, e)
+			continue
+		}
+		i.delete(e)
+	}
+
+	if len(kept) == len(i.users[userID]) {
+		return nil
+	}
+
+	if len(kept) == 0 {
+		delete(i.users, userID)
+	} else {
+		i.users[userID] = kept
+	}
+
+	return i.save()
+}
+
+// search returns the best score of every user having embedding in the same
+// bucket as e in any table. Candidates are ordered by score, best first.
+func (i *index) search(e Embedding) []Candidate {
+	// Index stays as is if file can't be reloaded, search must not fail.
+	i.mx.Lock()
+	i.refresh()
+	i.mx.Unlock()
+
+	i.mx.RLock()
+	defer i.mx.RUnlock()
+
+	scores := map[string]float64{}
+	seen := map[*indexEntry]bool{}
+
+	for t, k := range i.hash(e) {
+		for _, ie := range i.buckets[t][k] {
+			if seen[ie] {
+				continue
+			}
+			seen[ie] = true
+			if s := Similarity(e, ie.Embedding); s > scores[ie.UserID] {
+				scores[ie.UserID] = s
+			}
+		}
+	}
+
+	var cs []Candidate
+
+	for userID, s := range scores {
+		cs = append(cs, Candidate{UserID: userID, Score: s})
+	}
+
+	sortCandidates(cs)
+
+	return cs
+}
@@ -0,0 +1,213 @@
+package face
+
+import (
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testEmbedding returns normalized non negative embedding generated from seed.
+// Small noise gives embedding similar to the one without noise.
+func testEmbedding(seed int64, noise float64) Embedding {
+	rnd := rand.New(rand.NewSource(seed))
+	noiseRnd := rand.New(rand.NewSource(seed + 1000))
+
+	e := make(Embedding, embedGridSide*embedGridSide*lbpUniformBins)
+	for d := range e {
+		e[d] = float32(rnd.Float64() + noise*noiseRnd.Float64())
+	}
+
+	var norm float64
+	for _, v := range e {
+		norm += float64(v) * float64(v)
+	}
+	for d := range e {
+		e[d] /= float32(math.Sqrt(norm))
+	}
+
+	return e
+}
+
+// Small index makes similar embeddings share bucket in some table.
+const (
+	testIndexTables = 8
+	testIndexBits   = 4
+)
+
+// similarScore is a score of similar embeddings, random embeddings have
+// lower score.
+const similarScore = 0.95
+
+func TestIndex(t *testing.T) {
+	a, b, c := testEmbedding(1, 0), testEmbedding(2, 0), testEmbedding(3, 0)
+
+	add := func(t *testing.T, i *index, userID string, e Embedding, enrolled bool, photoID string, maxPerUser int) bool {
+		added, err := i.add(userID, e, enrolled, photoID, 0.999, maxPerUser)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return added
+	}
+
+	tests := []struct {
+		name string
+		// update updates index and returns index to search in.
+		update      func(t *testing.T, i *index) *index
+		query       Embedding
+		wantUsers   []string
+		wantEntries map[string]int
+	}{
+		{
+			name:        "empty",
+			update:      func(t *testing.T, i *index) *index { return i },
+			query:       a,
+			wantEntries: map[string]int{},
+		},
+		{
+			name: "best candidate first",
+			update: func(t *testing.T, i *index) *index {
+				add(t, i, "user-1", testEmbedding(1, 0.05), true, "p1", 10)
+				add(t, i, "user-2", a, true, "p2", 10)
+				return i
+			},
+			query:       a,
+			wantUsers:   []string{"user-2", "user-1"},
+			wantEntries: map[string]int{"user-1": 1, "user-2": 1},
+		},
+		{
+			name: "learned duplicate skipped",
+			update: func(t *testing.T, i *index) *index {
+				add(t, i, "user-1", a, true, "p1", 10)
+				if add(t, i, "user-1", a, false, "", 10) {
+					t.Error("duplicate is added")
+				}
+				return i
+			},
+			query:       a,
+			wantUsers:   []string{"user-1"},
+			wantEntries: map[string]int{"user-1": 1},
+		},
+		{
+			name: "oldest learned evicted",
+			update: func(t *testing.T, i *index) *index {
+				add(t, i, "user-1", a, true, "p1", 2)
+				add(t, i, "user-1", b, false, "", 2)
+				add(t, i, "user-1", c, false, "", 2)
+				return i
+			},
+			query:       b,
+			wantEntries: map[string]int{"user-1": 2},
+		},
+		{
+			name: "user removed",
+			update: func(t *testing.T, i *index) *index {
+				add(t, i, "user-1", a, true, "p1", 10)
+				add(t, i, "user-2", b, true, "p2", 10)
+				if err := i.removeUser("user-1"); err != nil {
+					t.Fatal(err)
+				}
+				return i
+			},
+			query:       a,
+			wantEntries: map[string]int{"user-2": 1},
+		},
+		{
+			name: "photo removed with learned embeddings",
+			update: func(t *testing.T, i *index) *index {
+				add(t, i, "user-1", a, true, "p1", 10)
+				add(t, i, "user-1", b, true, "p2", 10)
+				add(t, i, "user-1", c, false, "", 10)
+				if err := i.removePhoto("user-1", "p2"); err != nil {
+					t.Fatal(err)
+				}
+				return i
+			},
+			query:       a,
+			wantUsers:   []string{"user-1"},
+			wantEntries: map[string]int{"user-1": 1},
+		},
+		{
+			name: "persisted",
+			update: func(t *testing.T, i *index) *index {
+				add(t, i, "user-1", a, true, "p1", 10)
+				ri, err := openIndex(i.filePath, testIndexTables, testIndexBits)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return ri
+			},
+			query:       a,
+			wantUsers:   []string{"user-1"},
+			wantEntries: map[string]int{"user-1": 1},
+		},
+		{
+			name: "file updated by other process",
+			update: func(t *testing.T, i *index) *index {
+				add(t, i, "user-1", b, true, "p1", 10)
+				oi, err := openIndex(i.filePath, testIndexTables, testIndexBits)
+				if err != nil {
+					t.Fatal(err)
+				}
+				add(t, oi, "user-2", a, true, "p2", 10)
+				// Update is applied to the reloaded content.
+				add(t, i, "user-3", c, true, "p3", 10)
+				return i
+			},
+			query:       a,
+			wantUsers:   []string{"user-2"},
+			wantEntries: map[string]int{"user-1": 1, "user-2": 1, "user-3": 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dirPath, err := ioutil.TempDir("", "index-test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dirPath)
+
+			i, err := openIndex(filepath.Join(dirPath, "index.json"), testIndexTables, testIndexBits)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			i = tt.update(t, i)
+
+			cs := i.search(tt.query)
+
+			var users []string
+			for _, c := range cs {
+				if c.Score >= similarScore {
+					users = append(users, c.UserID)
+				}
+			}
+
+			if len(users) != len(tt.wantUsers) {
+				t.Fatalf("candidates = %v, want users %v", cs, tt.wantUsers)
+			}
+			for j := range users {
+				if users[j] != tt.wantUsers[j] {
+					t.Errorf("candidates = %v, want users %v", cs, tt.wantUsers)
+				}
+			}
+
+			entries := map[string]int{}
+			for userID, es := range i.users {
+				entries[userID] = len(es)
+			}
+
+			if len(entries) != len(tt.wantEntries) {
+				t.Fatalf("entries = %v, want %v", entries, tt.wantEntries)
+			}
+			for userID, n := range tt.wantEntries {
+				if entries[userID] != n {
+					t.Errorf("entries = %v, want %v", entries, tt.wantEntries)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+// Package liveness implements anti-spoofing checks of a face on a burst of
+// camera frames. Checks don't depend on face backend and use only frames
+// themselves.
+package liveness
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/dimuls/oko/face"
+)
+
+const (
+	defaultMinMotion        = 1.5
+	defaultBlinkRatio       = 1.8
+	defaultMaxHighFrequency = 0.25
+	defaultMaxGlare         = 0.05
+
+	glareLevel = 250
+)
+
+type Config struct {
+	// MinMotion is a minimal mean absolute difference of face pixels between
+	// consecutive frames. Printed photo on a stand or frozen screen moves
+	// less than a real person.
+	MinMotion float64 `yaml:"min_motion"`
+
+	// BlinkRatio is a minimal ratio of eyes region motion to the rest of face
+	// motion to consider that user blinked.
+	BlinkRatio float64 `yaml:"blink_ratio"`
+
+	// RequireBlink makes blink mandatory for the face to be live.
+	RequireBlink bool `yaml:"require_blink"`
+
+	// MaxHighFrequency is a maximal ratio of mean absolute Laplacian to mean
+	// brightness of the face. Screen pixel grid and printing raster produce
+	// moiré which increases high frequency energy.
+	MaxHighFrequency float64 `yaml:"max_high_frequency"`
+
+	// MaxGlare is a maximal share of overexposed face pixels. Screens and
+	// glossy photos reflect light.
+	MaxGlare float64 `yaml:"max_glare"`
+}
+
+func (c *Config) setDefaults() {
+	if c.MinMotion == 0 {
+		c.MinMotion = defaultMinMotion
+	}
+	if c.BlinkRatio == 0 {
+		c.BlinkRatio = defaultBlinkRatio
+	}
+	if c.MaxHighFrequency == 0 {
+		c.MaxHighFrequency = defaultMaxHighFrequency
+	}
+	if c.MaxGlare == 0 {
+		c.MaxGlare = defaultMaxGlare
+	}
+}
+
+type Reason string
+
+const (
+	ReasonNoMotion Reason = "no_motion"
+	ReasonNoBlink  Reason = "no_blink"
+	ReasonMoire    Reason = "moire"
+	ReasonGlare    Reason = "glare"
+)
+
+type Result struct {
+	Live          bool     `json:"live"`
+	Motion        float64  `json:"motion"`
+	Blinked       bool     `json:"blinked"`
+	HighFrequency float64  `json:"high_frequency"`
+	Glare         float64  `json:"glare"`
+	Reasons       []Reason `json:"reasons"`
+}
+
+// gray returns grayscale pixels of the box of the image.
+func gray(img image.Image, box image.Rectangle) []float64 {
+	g := make([]float64, 0, box.Dx()*box.Dy())
+	for y := box.Min.Y; y < box.Max.Y; y++ {
+		for x := box.Min.X; x < box.Max.X; x++ {
+			g = append(g, float64(color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y))
+		}
+	}
+	return g
+}
+
+func meanAbsDiff(a, b []float64) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+	var s float64
+	for i := range a {
+		s += math.Abs(a[i] - b[i])
+	}
+	return s / float64(len(a))
+}
+
+// highFrequency returns high frequency energy and glare share of the face.
+func highFrequency(g []float64, w, h int) (float64, float64) {
+	if w < 3 || h < 3 {
+		return 0, 0
+	}
+
+	var lap, brightness, glare float64
+
+	for _, v := range g {
+		brightness += v
+		if v >= glareLevel {
+			glare++
+		}
+	}
+
+	for y := 1; y < h-1; y++ {
+		for x := 1; x < w-1; x++ {
+			i := y*w + x
+			lap += math.Abs(4*g[i] - g[i-1] - g[i+1] - g[i-w] - g[i+w])
+		}
+	}
+
+	if brightness == 0 {
+		return 0, 0
+	}
+
+	brightness /= float64(len(g))
+
+	return lap / float64((w-2)*(h-2)) / brightness, glare / float64(len(g))
+}
+
+// faceBox finds the face on the first frame, falls back to the given box and
+// then to the whole frame.
+func faceBox(frames []image.Image, box image.Rectangle) image.Rectangle {
+	bounds := frames[0].Bounds()
+	if faces := face.Detect(frames[0]); len(faces) > 0 {
+		return faces[0]
+	}
+	if !box.Intersect(bounds).Empty() {
+		return box.Intersect(bounds)
+	}
+	return bounds
+}
+
+// Check analyses burst of frames of the same face. Box is a face bounding box
+// known from recognition and may be empty.
+func Check(frames []image.Image, box image.Rectangle, c Config) Result {
+	c.setDefaults()
+
+	var r Result
+
+	if len(frames) < 2 {
+		r.Reasons = append(r.Reasons, ReasonNoMotion)
+		return r
+	}
+
+	box = faceBox(frames, box)
+
+	// Eyes are in the upper part of the face box, the rest of the face is
+	// used as a reference of the whole head motion.
+	eyes := image.Rect(box.Min.X, box.Min.Y+box.Dy()/5, box.Max.X, box.Min.Y+box.Dy()/2)
+	rest := image.Rect(box.Min.X, box.Min.Y+box.Dy()/2, box.Max.X, box.Max.Y)
+
+	var (
+		prevFace, prevEyes, prevRest []float64
+		maxBlinkRatio                float64
+	)
+
+	for i, f := range frames {
+		fg := gray(f, box)
+		eg := gray(f, eyes)
+		rg := gray(f, rest)
+
+		if i == 0 {
+			r.HighFrequency, r.Glare = highFrequency(fg, box.Dx(), box.Dy())
+		} else {
+			r.Motion += meanAbsDiff(prevFace, fg)
+			em, rm := meanAbsDiff(prevEyes, eg), meanAbsDiff(prevRest, rg)
+			if ratio := em / math.Max(rm, 1); ratio > maxBlinkRatio {
+				maxBlinkRatio = ratio
+			}
+		}
+
+		prevFace, prevEyes, prevRest = fg, eg, rg
+	}
+
+	r.Motion /= float64(len(frames) - 1)
+	r.Blinked = maxBlinkRatio >= c.BlinkRatio
+
+	if r.Motion < c.MinMotion && !r.Blinked {
+		r.Reasons = append(r.Reasons, ReasonNoMotion)
+	}
+
+	if c.RequireBlink && !r.Blinked {
+		r.Reasons = append(r.Reasons, ReasonNoBlink)
+	}
+
+	if r.HighFrequency > c.MaxHighFrequency {
+		r.Reasons = append(r.Reasons, ReasonMoire)
+	}
+
+	if r.Glare > c.MaxGlare {
+		r.Reasons = append(r.Reasons, ReasonGlare)
+	}
+
+	r.Live = len(r.Reasons) == 0
+
+	return r
+}
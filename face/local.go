@@ -0,0 +1,164 @@
+package face
+
+import (
+	"context"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+)
+
+const (
+	LocalBackend = "local"
+
+	defaultLocalMaxCandidates = 5
+)
+
+type LocalConfig struct {
+	GalleryDirectoryPath string `yaml:"gallery_directory_path"`
+	MaxCandidates        int    `yaml:"max_candidates"`
+}
+
+// Local is a face backend which works without any remote service. It detects
+// faces, extracts embeddings and matches them against on-disk gallery.
+type Local struct {
+	config  LocalConfig
+	gallery *gallery
+}
+
+func NewLocal(c LocalConfig) (*Local, error) {
+	if c.GalleryDirectoryPath == "" {
+		return nil, fmt.Errorf("gallery_directory_path is not set")
+	}
+
+	if c.MaxCandidates == 0 {
+		c.MaxCandidates = defaultLocalMaxCandidates
+	}
+
+	g, err := openGallery(c.GalleryDirectoryPath)
+	if err != nil {
+		return nil, fmt.Errorf("open gallery: %w", err)
+	}
+
+	return &Local{config: c, gallery: g}, nil
+}
+
+func init() {
+	Register(LocalBackend, func(decode func(interface{}) error) (Backend, error) {
+		var c LocalConfig
+		err := decode(&c)
+		if err != nil {
+			return nil, fmt.Errorf("decode config: %w", err)
+		}
+		return NewLocal(c)
+	})
+}
+
+func embedLargestFace(photo io.Reader) (Embedding, image.Rectangle, error) {
+	img, _, err := image.Decode(photo)
+	if err != nil {
+		return nil, image.Rectangle{}, &Error{Category: CategoryInvalidImage, Message: "decode image", Err: err}
+	}
+
+	faces := Detect(img)
+	if len(faces) == 0 {
+		return nil, image.Rectangle{}, ErrFaceNotFound
+	}
+
+	return Embed(img, faces[0]), faces[0], nil
+}
+
+func embedPhotoFile(photoFilePath string) (Embedding, error) {
+	f, err := os.Open(photoFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("open photo file: %w", err)
+	}
+	defer f.Close()
+
+	e, _, err := embedLargestFace(f)
+	return e, err
+}
+
+func (l *Local) AddUser(ctx context.Context, photoFilePath string) (string, error) {
+	e, err := embedPhotoFile(photoFilePath)
+	if err != nil {
+		return "", err
+	}
+
+	userID, err := newID()
+	if err != nil {
+		return "", fmt.Errorf("generate user ID: %w", err)
+	}
+
+	_, err = l.gallery.addPhoto(userID, e)
+	if err != nil {
+		return "", fmt.Errorf("add photo to gallery: %w", err)
+	}
+
+	return userID, nil
+}
+
+func (l *Local) AddUserPhoto(ctx context.Context, userID string, photoFilePath string) (string, error) {
+	if !l.gallery.exists(userID) {
+		return "", ErrUserNotFound
+	}
+
+	e, err := embedPhotoFile(photoFilePath)
+	if err != nil {
+		return "", err
+	}
+
+	photoID, err := l.gallery.addPhoto(userID, e)
+	if err != nil {
+		return "", fmt.Errorf("add photo to gallery: %w", err)
+	}
+
+	return photoID, nil
+}
+
+func (l *Local) RemoveUserPhoto(ctx context.Context, userID string, photoID string) error {
+	return l.gallery.removePhoto(userID, photoID)
+}
+
+func (l *Local) RecognizeUser(ctx context.Context, photo io.Reader) (Recognition, error) {
+	e, box, err := embedLargestFace(photo)
+	if err != nil {
+		return Recognition{}, err
+	}
+
+	cs := l.gallery.search(e)
+	if len(cs) > l.config.MaxCandidates {
+		cs = cs[:l.config.MaxCandidates]
+	}
+
+	return Recognition{Box: box, Candidates: cs}, nil
+}
+
+func (l *Local) RecognizeUsers(ctx context.Context, photo io.Reader) ([]Recognition, error) {
+	img, _, err := image.Decode(photo)
+	if err != nil {
+		return nil, &Error{Category: CategoryInvalidImage, Message: "decode image", Err: err}
+	}
+
+	var rs []Recognition
+
+	for _, box := range Detect(img) {
+		cs := l.gallery.search(Embed(img, box))
+		if len(cs) > l.config.MaxCandidates {
+			cs = cs[:l.config.MaxCandidates]
+		}
+		rs = append(rs, Recognition{Box: box, Candidates: cs})
+	}
+
+	if len(rs) == 0 {
+		return nil, ErrFaceNotFound
+	}
+
+	return rs, nil
+}
+
+func (l *Local) RemoveUser(ctx context.Context, userID string) error {
+	return l.gallery.removeUser(userID)
+}
@@ -0,0 +1,185 @@
+package face
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+)
+
+const (
+	defaultQualityMinSide       = 320
+	defaultQualityMinFaceSide   = 100
+	defaultQualityMinSharpness  = 50
+	defaultQualityMinBrightness = 60
+	defaultQualityMaxBrightness = 200
+	defaultQualityMaxClipped    = 0.1
+
+	clippedDarkLevel  = 5
+	clippedLightLevel = 250
+)
+
+type QualityConfig struct {
+	MinSide       int     `yaml:"min_side"`
+	MinFaceSide   int     `yaml:"min_face_side"`
+	MinSharpness  float64 `yaml:"min_sharpness"`
+	MinBrightness float64 `yaml:"min_brightness"`
+	MaxBrightness float64 `yaml:"max_brightness"`
+	MaxClipped    float64 `yaml:"max_clipped"`
+}
+
+func (c *QualityConfig) setDefaults() {
+	if c.MinSide == 0 {
+		c.MinSide = defaultQualityMinSide
+	}
+	if c.MinFaceSide == 0 {
+		c.MinFaceSide = defaultQualityMinFaceSide
+	}
+	if c.MinSharpness == 0 {
+		c.MinSharpness = defaultQualityMinSharpness
+	}
+	if c.MinBrightness == 0 {
+		c.MinBrightness = defaultQualityMinBrightness
+	}
+	if c.MaxBrightness == 0 {
+		c.MaxBrightness = defaultQualityMaxBrightness
+	}
+	if c.MaxClipped == 0 {
+		c.MaxClipped = defaultQualityMaxClipped
+	}
+}
+
+// QualityReport describes photo quality. Errors make photo unusable for
+// enrollment, warnings make recognition less reliable.
+type QualityReport struct {
+	Width       int      `json:"width"`
+	Height      int      `json:"height"`
+	Orientation int      `json:"orientation"`
+	Faces       int      `json:"faces"`
+	FaceSide    int      `json:"face_side"`
+	Sharpness   float64  `json:"sharpness"`
+	Brightness  float64  `json:"brightness"`
+	Clipped     float64  `json:"clipped"`
+	Errors      []string `json:"errors"`
+	Warnings    []string `json:"warnings"`
+}
+
+func (r QualityReport) OK(strict bool) bool {
+	return len(r.Errors) == 0 && (!strict || len(r.Warnings) == 0)
+}
+
+// faceMetrics returns sharpness as a variance of Laplacian, mean brightness
+// and share of clipped pixels of the face.
+func faceMetrics(img image.Image, box image.Rectangle) (sharpness, brightness, clipped float64) {
+	w, h := box.Dx(), box.Dy()
+	if w < 3 || h < 3 {
+		return
+	}
+
+	g := make([]float64, w*h)
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := float64(color.GrayModel.Convert(img.At(box.Min.X+x, box.Min.Y+y)).(color.Gray).Y)
+			g[y*w+x] = v
+			brightness += v
+			if v <= clippedDarkLevel || v >= clippedLightLevel {
+				clipped++
+			}
+		}
+	}
+
+	brightness /= float64(len(g))
+	clipped /= float64(len(g))
+
+	var sum, sumSq float64
+	n := float64((w - 2) * (h - 2))
+
+	for y := 1; y < h-1; y++ {
+		for x := 1; x < w-1; x++ {
+			i := y*w + x
+			l := 4*g[i] - g[i-1] - g[i+1] - g[i-w] - g[i+w]
+			sum += l
+			sumSq += l * l
+		}
+	}
+
+	sharpness = sumSq/n - (sum/n)*(sum/n)
+
+	return
+}
+
+// CheckQuality decodes photo, normalizes its EXIF orientation and checks that
+// it is suitable for enrollment. Normalized image is returned along with the
+// report.
+func CheckQuality(photo []byte, c QualityConfig) (image.Image, QualityReport, error) {
+	c.setDefaults()
+
+	var r QualityReport
+
+	img, _, err := image.Decode(bytes.NewReader(photo))
+	if err != nil {
+		return nil, r, &Error{Category: CategoryInvalidImage, Message: "decode image", Err: err}
+	}
+
+	r.Orientation, err = exifOrientation(photo)
+	if err != nil {
+		return nil, r, &Error{Category: CategoryInvalidImage, Message: "read EXIF orientation", Err: err}
+	}
+
+	img = orient(img, r.Orientation)
+
+	r.Width, r.Height = img.Bounds().Dx(), img.Bounds().Dy()
+
+	if r.Width < c.MinSide || r.Height < c.MinSide {
+		r.Errors = append(r.Errors, fmt.Sprintf("resolution %dx%d is less than minimal %dx%d",
+			r.Width, r.Height, c.MinSide, c.MinSide))
+	}
+
+	faces := Detect(img)
+	r.Faces = len(faces)
+
+	switch {
+	case r.Faces == 0:
+		r.Errors = append(r.Errors, "face not found")
+		return img, r, nil
+	case r.Faces > 1:
+		r.Warnings = append(r.Warnings, fmt.Sprintf("%d faces found instead of one, the largest one is checked", r.Faces))
+	}
+
+	box := faces[0]
+
+	r.FaceSide = box.Dx()
+	if box.Dy() < r.FaceSide {
+		r.FaceSide = box.Dy()
+	}
+
+	if r.FaceSide < c.MinFaceSide {
+		r.Warnings = append(r.Warnings, fmt.Sprintf("face size %d is less than minimal %d",
+			r.FaceSide, c.MinFaceSide))
+	}
+
+	r.Sharpness, r.Brightness, r.Clipped = faceMetrics(img, box)
+
+	if r.Sharpness < c.MinSharpness {
+		r.Warnings = append(r.Warnings, fmt.Sprintf("face is blurred: sharpness %.1f is less than minimal %.1f",
+			r.Sharpness, c.MinSharpness))
+	}
+
+	if r.Brightness < c.MinBrightness {
+		r.Warnings = append(r.Warnings, fmt.Sprintf("face is underexposed: brightness %.1f is less than minimal %.1f",
+			r.Brightness, c.MinBrightness))
+	}
+
+	if r.Brightness > c.MaxBrightness {
+		r.Warnings = append(r.Warnings, fmt.Sprintf("face is overexposed: brightness %.1f is greater than maximal %.1f",
+			r.Brightness, c.MaxBrightness))
+	}
+
+	if r.Clipped > c.MaxClipped {
+		r.Warnings = append(r.Warnings, fmt.Sprintf("clipped pixels share %.2f is greater than maximal %.2f",
+			r.Clipped, c.MaxClipped))
+	}
+
+	return img, r, nil
+}
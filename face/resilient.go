@@ -0,0 +1,341 @@
+package face
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTimeout                  = "10s"
+	defaultRetryMaxAttempts         = 3
+	defaultRetryInitialBackoff      = "200ms"
+	defaultRetryMaxBackoff          = "5s"
+	defaultCircuitBreakerThreshold  = 5
+	defaultCircuitBreakerOpenPeriod = "30s"
+)
+
+// ErrCircuitOpen is wrapped into unavailable category error when circuit
+// breaker fails call fast.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+type RetryConfig struct {
+	MaxAttempts    int    `yaml:"max_attempts"`
+	InitialBackoff string `yaml:"initial_backoff"`
+	MaxBackoff     string `yaml:"max_backoff"`
+}
+
+type CircuitBreakerConfig struct {
+	FailureThreshold int    `yaml:"failure_threshold"`
+	OpenPeriod       string `yaml:"open_period"`
+}
+
+type ResilienceConfig struct {
+	Timeout        string               `yaml:"timeout"`
+	Retry          RetryConfig          `yaml:"retry"`
+	CircuitBreaker CircuitBreakerConfig `yaml:"circuit_breaker"`
+}
+
+// Degradable is implemented by backends which can report that they work in
+// degraded mode.
+type Degradable interface {
+	Degraded() bool
+}
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// Resilient wraps backend with per call timeouts, retries of idempotent calls
+// with exponential backoff and jitter, and circuit breaker which fails calls
+// fast after repeated backend failures.
+type Resilient struct {
+	backend Backend
+
+	timeout          time.Duration
+	maxAttempts      int
+	initialBackoff   time.Duration
+	maxBackoff       time.Duration
+	failureThreshold int
+	openPeriod       time.Duration
+
+	mx       sync.Mutex
+	state    circuitState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func parseDurationDefault(name, value, def string) (time.Duration, error) {
+	if value == "" {
+		value = def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("parse %s: %w", name, err)
+	}
+	return d, nil
+}
+
+func NewResilient(b Backend, c ResilienceConfig) (*Resilient, error) {
+	r := &Resilient{
+		backend:          b,
+		maxAttempts:      c.Retry.MaxAttempts,
+		failureThreshold: c.CircuitBreaker.FailureThreshold,
+	}
+
+	if r.maxAttempts == 0 {
+		r.maxAttempts = defaultRetryMaxAttempts
+	}
+
+	if r.failureThreshold == 0 {
+		r.failureThreshold = defaultCircuitBreakerThreshold
+	}
+
+	var err error
+
+	r.timeout, err = parseDurationDefault("timeout", c.Timeout, defaultTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	r.initialBackoff, err = parseDurationDefault("retry initial_backoff",
+		c.Retry.InitialBackoff, defaultRetryInitialBackoff)
+	if err != nil {
+		return nil, err
+	}
+
+	r.maxBackoff, err = parseDurationDefault("retry max_backoff",
+		c.Retry.MaxBackoff, defaultRetryMaxBackoff)
+	if err != nil {
+		return nil, err
+	}
+
+	r.openPeriod, err = parseDurationDefault("circuit_breaker open_period",
+		c.CircuitBreaker.OpenPeriod, defaultCircuitBreakerOpenPeriod)
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *Resilient) Close() error {
+	return Close(r.backend)
+}
+
+// Degraded reports whether circuit breaker is open or probes backend after
+// being open.
+func (r *Resilient) Degraded() bool {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	return r.state != circuitClosed
+}
+
+func (r *Resilient) allow() bool {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	switch r.state {
+	case circuitOpen:
+		if time.Since(r.openedAt) < r.openPeriod {
+			return false
+		}
+		r.state = circuitHalfOpen
+		r.probing = true
+		return true
+	case circuitHalfOpen:
+		if r.probing {
+			return false
+		}
+		r.probing = true
+		return true
+	}
+
+	return true
+}
+
+// abort releases probe without changing circuit breaker state.
+func (r *Resilient) abort() {
+	r.mx.Lock()
+	r.probing = false
+	r.mx.Unlock()
+}
+
+func (r *Resilient) record(failed bool) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	r.probing = false
+
+	if !failed {
+		r.state = circuitClosed
+		r.failures = 0
+		return
+	}
+
+	r.failures++
+
+	if r.state == circuitHalfOpen || r.failures >= r.failureThreshold {
+		r.state = circuitOpen
+		r.openedAt = time.Now()
+	}
+}
+
+// backendFailure reports whether error means that backend doesn't work
+// properly, as opposed to errors caused by the request itself.
+func backendFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	switch Category(err) {
+	case CategoryUnavailable, CategoryUnknown:
+		return true
+	}
+	return false
+}
+
+func (r *Resilient) backoff(attempt int) time.Duration {
+	d := r.initialBackoff << uint(attempt-1)
+	if d > r.maxBackoff || d <= 0 {
+		d = r.maxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// call calls f respecting circuit breaker state. Idempotent calls are retried
+// on backend failures.
+func (r *Resilient) call(ctx context.Context, idempotent bool, f func(ctx context.Context, attempt int) error) error {
+	attempts := 1
+	if idempotent {
+		attempts = r.maxAttempts
+	}
+
+	var err error
+
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-time.After(r.backoff(attempt - 1)):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		if !r.allow() {
+			return &Error{Category: CategoryUnavailable, Err: ErrCircuitOpen}
+		}
+
+		callCtx, cancel := context.WithTimeout(ctx, r.timeout)
+		err = f(callCtx, attempt)
+		cancel()
+
+		if ctx.Err() != nil {
+			r.abort()
+			return err
+		}
+
+		failed := backendFailure(err)
+		r.record(failed)
+
+		if !failed {
+			return err
+		}
+	}
+
+	return err
+}
+
+func readPhoto(photo io.Reader) ([]byte, error) {
+	p, err := ioutil.ReadAll(photo)
+	if err != nil {
+		return nil, fmt.Errorf("read photo: %w", err)
+	}
+	return p, nil
+}
+
+func (r *Resilient) RecognizeUser(ctx context.Context, photo io.Reader) (Recognition, error) {
+	p, err := readPhoto(photo)
+	if err != nil {
+		return Recognition{}, err
+	}
+
+	var rec Recognition
+
+	err = r.call(ctx, true, func(ctx context.Context, attempt int) (err error) {
+		rec, err = r.backend.RecognizeUser(ctx, bytes.NewReader(p))
+		return
+	})
+
+	return rec, err
+}
+
+func (r *Resilient) RecognizeUsers(ctx context.Context, photo io.Reader) ([]Recognition, error) {
+	p, err := readPhoto(photo)
+	if err != nil {
+		return nil, err
+	}
+
+	var rs []Recognition
+
+	err = r.call(ctx, true, func(ctx context.Context, attempt int) (err error) {
+		rs, err = RecognizeUsers(ctx, r.backend, bytes.NewReader(p))
+		return
+	})
+
+	return rs, err
+}
+
+func (r *Resilient) AddUser(ctx context.Context, photoFilePath string) (string, error) {
+	var userID string
+
+	err := r.call(ctx, false, func(ctx context.Context, attempt int) (err error) {
+		userID, err = r.backend.AddUser(ctx, photoFilePath)
+		return
+	})
+
+	return userID, err
+}
+
+func (r *Resilient) AddUserPhoto(ctx context.Context, userID string, photoFilePath string) (string, error) {
+	var photoID string
+
+	err := r.call(ctx, false, func(ctx context.Context, attempt int) (err error) {
+		photoID, err = r.backend.AddUserPhoto(ctx, userID, photoFilePath)
+		return
+	})
+
+	return photoID, err
+}
+
+func (r *Resilient) RemoveUserPhoto(ctx context.Context, userID string, photoID string) error {
+	return r.call(ctx, true, func(ctx context.Context, attempt int) error {
+		err := r.backend.RemoveUserPhoto(ctx, userID, photoID)
+		// Previous attempt could remove photo but fail to return response.
+		if attempt > 1 && errors.Is(err, ErrPhotoNotFound) {
+			return nil
+		}
+		return err
+	})
+}
+
+func (r *Resilient) RemoveUser(ctx context.Context, userID string) error {
+	return r.call(ctx, true, func(ctx context.Context, attempt int) error {
+		err := r.backend.RemoveUser(ctx, userID)
+		// Previous attempt could remove user but fail to return response.
+		if attempt > 1 && errors.Is(err, ErrUserNotFound) {
+			return nil
+		}
+		return err
+	})
+}
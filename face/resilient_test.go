@@ -0,0 +1,146 @@
+package face_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/dimuls/oko/face"
+	"github.com/dimuls/oko/face/facetest"
+)
+
+func TestResilient(t *testing.T) {
+	photo := []byte("photo")
+
+	tests := []struct {
+		name string
+		c    face.ResilienceConfig
+		// failure is face API response status code, zero means success.
+		failure int
+		calls   int
+		// recover disables failure before the last call after open period.
+		recover      bool
+		wantRequests int
+		wantCategory face.ErrorCategory
+		wantOpen     bool
+		wantDegraded bool
+	}{
+		{
+			name:         "success",
+			calls:        1,
+			wantRequests: 1,
+		},
+		{
+			name:         "retry unavailable",
+			c:            face.ResilienceConfig{Retry: face.RetryConfig{MaxAttempts: 3}},
+			failure:      http.StatusServiceUnavailable,
+			calls:        1,
+			wantRequests: 3,
+			wantCategory: face.CategoryUnavailable,
+		},
+		{
+			name:         "no retry of invalid request",
+			c:            face.ResilienceConfig{Retry: face.RetryConfig{MaxAttempts: 3}},
+			failure:      http.StatusBadRequest,
+			calls:        1,
+			wantRequests: 1,
+			wantCategory: face.CategoryInvalidRequest,
+		},
+		{
+			name: "circuit breaker opens",
+			c: face.ResilienceConfig{
+				Retry:          face.RetryConfig{MaxAttempts: 1},
+				CircuitBreaker: face.CircuitBreakerConfig{FailureThreshold: 2},
+			},
+			failure:      http.StatusServiceUnavailable,
+			calls:        3,
+			wantRequests: 2,
+			wantCategory: face.CategoryUnavailable,
+			wantOpen:     true,
+			wantDegraded: true,
+		},
+		{
+			name: "circuit breaker closes after successful probe",
+			c: face.ResilienceConfig{
+				Retry:          face.RetryConfig{MaxAttempts: 1},
+				CircuitBreaker: face.CircuitBreakerConfig{FailureThreshold: 1, OpenPeriod: "50ms"},
+			},
+			failure:      http.StatusServiceUnavailable,
+			calls:        2,
+			recover:      true,
+			wantRequests: 2,
+		},
+		{
+			name: "circuit breaker reopens after failed probe",
+			c: face.ResilienceConfig{
+				Retry:          face.RetryConfig{MaxAttempts: 1},
+				CircuitBreaker: face.CircuitBreakerConfig{FailureThreshold: 1, OpenPeriod: "50ms"},
+			},
+			failure:      http.StatusServiceUnavailable,
+			calls:        3,
+			wantRequests: 2,
+			wantCategory: face.CategoryUnavailable,
+			wantOpen:     true,
+			wantDegraded: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := facetest.NewServer()
+			defer fs.Close()
+
+			fs.Enroll("user-1", photo)
+			fs.SetFailure(tt.failure)
+
+			api, err := face.NewAPI(fs.Config())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tt.c.Retry.InitialBackoff = "1ms"
+			tt.c.Retry.MaxBackoff = "2ms"
+
+			r, err := face.NewResilient(api, tt.c)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for c := 1; c <= tt.calls; c++ {
+				if c == tt.calls && tt.recover {
+					fs.SetFailure(0)
+				}
+
+				// The second call after opening probes backend.
+				if c == 2 && tt.c.CircuitBreaker.OpenPeriod != "" {
+					time.Sleep(60 * time.Millisecond)
+				}
+
+				_, err = r.RecognizeUser(context.Background(), bytes.NewReader(photo))
+			}
+
+			if tt.wantCategory == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+			} else if got := face.Category(err); got != tt.wantCategory {
+				t.Errorf("error category = %q, want %q: %v", got, tt.wantCategory, err)
+			}
+
+			if got := errors.Is(err, face.ErrCircuitOpen); got != tt.wantOpen {
+				t.Errorf("circuit open error = %v, want %v: %v", got, tt.wantOpen, err)
+			}
+
+			if got := fs.Requests(); got != tt.wantRequests {
+				t.Errorf("requests = %d, want %d", got, tt.wantRequests)
+			}
+
+			if got := r.Degraded(); got != tt.wantDegraded {
+				t.Errorf("degraded = %v, want %v", got, tt.wantDegraded)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package face
+
+import (
+	"image"
+	"image/color"
+	"math/bits"
+)
+
+const signatureSide = 32
+
+// Signature is a compact frame description used to detect scene changes. It
+// consists of difference hash and small grayscale thumbnail.
+type Signature struct {
+	Hash  uint64
+	Thumb []uint8
+}
+
+// thumbnail scales image down to w x h grayscale thumbnail by averaging.
+func thumbnail(img image.Image, w, h int) []uint8 {
+	b := img.Bounds()
+	t := make([]uint8, w*h)
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var sum, n int
+			for sy := b.Min.Y + y*b.Dy()/h; sy < b.Min.Y+(y+1)*b.Dy()/h; sy++ {
+				for sx := b.Min.X + x*b.Dx()/w; sx < b.Min.X+(x+1)*b.Dx()/w; sx++ {
+					sum += int(color.GrayModel.Convert(img.At(sx, sy)).(color.Gray).Y)
+					n++
+				}
+			}
+			if n > 0 {
+				t[y*w+x] = uint8(sum / n)
+			}
+		}
+	}
+
+	return t
+}
+
+func Sign(img image.Image) Signature {
+	s := Signature{Thumb: thumbnail(img, signatureSide, signatureSide)}
+
+	// Difference hash of 9x8 thumbnail: bit is set if pixel is brighter than
+	// its right neighbour.
+	h := thumbnail(img, 9, 8)
+
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if h[y*9+x] > h[y*9+x+1] {
+				s.Hash |= 1 << uint(y*8+x)
+			}
+		}
+	}
+
+	return s
+}
+
+// Distance returns Hamming distance between signatures hashes.
+func (s Signature) Distance(o Signature) int {
+	return bits.OnesCount64(s.Hash ^ o.Hash)
+}
+
+// Difference returns mean absolute difference between signatures thumbnails.
+func (s Signature) Difference(o Signature) float64 {
+	if len(s.Thumb) != len(o.Thumb) || len(s.Thumb) == 0 {
+		return 255
+	}
+
+	var sum int
+
+	for i := range s.Thumb {
+		d := int(s.Thumb[i]) - int(o.Thumb[i])
+		if d < 0 {
+			d = -d
+		}
+		sum += d
+	}
+
+	return float64(sum) / float64(len(s.Thumb))
+}
@@ -0,0 +1,213 @@
+// Package tamper detects camera tampering and obstruction by analysing
+// sequence of camera frames: covered lens, black or white frames, frozen
+// feed and sudden viewpoint change.
+package tamper
+
+import (
+	"hash/fnv"
+	"image"
+	"image/color"
+	"math"
+)
+
+const (
+	defaultBlackLevel          = 20
+	defaultWhiteLevel          = 235
+	defaultMinContrast         = 8
+	defaultFrozenFrames        = 3
+	defaultViewpointDifference = 35
+	defaultViewpointFrames     = 3
+
+	backgroundSide = 16
+
+	// referenceWeight is a weight of the current frame when background
+	// reference follows slow changes such as lighting.
+	referenceWeight = 0.1
+)
+
+type Config struct {
+	// BlackLevel and WhiteLevel are mean brightness limits of the frame.
+	BlackLevel float64 `yaml:"black_level"`
+	WhiteLevel float64 `yaml:"white_level"`
+
+	// MinContrast is a minimal standard deviation of the frame brightness.
+	// Covered lens produces almost uniform frame.
+	MinContrast float64 `yaml:"min_contrast"`
+
+	// FrozenFrames is a number of consecutive pixel identical frames to
+	// consider feed frozen. Real camera noise never produces identical
+	// frames.
+	FrozenFrames int `yaml:"frozen_frames"`
+
+	// ViewpointDifference is a minimal mean absolute difference of frame
+	// background from the reference, which must hold for ViewpointFrames
+	// consecutive frames to consider that camera was turned.
+	ViewpointDifference float64 `yaml:"viewpoint_difference"`
+	ViewpointFrames     int     `yaml:"viewpoint_frames"`
+}
+
+func (c *Config) setDefaults() {
+	if c.BlackLevel == 0 {
+		c.BlackLevel = defaultBlackLevel
+	}
+	if c.WhiteLevel == 0 {
+		c.WhiteLevel = defaultWhiteLevel
+	}
+	if c.MinContrast == 0 {
+		c.MinContrast = defaultMinContrast
+	}
+	if c.FrozenFrames == 0 {
+		c.FrozenFrames = defaultFrozenFrames
+	}
+	if c.ViewpointDifference == 0 {
+		c.ViewpointDifference = defaultViewpointDifference
+	}
+	if c.ViewpointFrames == 0 {
+		c.ViewpointFrames = defaultViewpointFrames
+	}
+}
+
+type Reason string
+
+const (
+	ReasonBlack            Reason = "black_frame"
+	ReasonWhite            Reason = "white_frame"
+	ReasonCovered          Reason = "covered_lens"
+	ReasonFrozen           Reason = "frozen_feed"
+	ReasonViewpointChanged Reason = "viewpoint_changed"
+)
+
+// Frame is a description of camera frame.
+type Frame struct {
+	Brightness float64
+	Contrast   float64
+	Checksum   uint64
+
+	// Background is a thumbnail of the frame borders, where user usually
+	// isn't present. Bottom border is excluded as it is occupied by user's
+	// body.
+	Background []float64
+}
+
+func isBackground(x, y int) bool {
+	border := backgroundSide / 4
+	return y < border || x < border || x >= backgroundSide-border
+}
+
+func Analyze(img image.Image) Frame {
+	b := img.Bounds()
+
+	var (
+		f          Frame
+		sum, sumSq float64
+		cells      [backgroundSide * backgroundSide]float64
+		counts     [backgroundSide * backgroundSide]int
+	)
+
+	h := fnv.New64a()
+	row := make([]byte, b.Dx())
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			v := color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y
+			row[x-b.Min.X] = v
+			sum += float64(v)
+			sumSq += float64(v) * float64(v)
+			c := ((y-b.Min.Y)*backgroundSide/b.Dy())*backgroundSide + (x-b.Min.X)*backgroundSide/b.Dx()
+			cells[c] += float64(v)
+			counts[c]++
+		}
+		h.Write(row)
+	}
+
+	n := float64(b.Dx() * b.Dy())
+	if n == 0 {
+		return f
+	}
+
+	f.Brightness = sum / n
+	f.Contrast = math.Sqrt(math.Max(sumSq/n-f.Brightness*f.Brightness, 0))
+	f.Checksum = h.Sum64()
+
+	for y := 0; y < backgroundSide; y++ {
+		for x := 0; x < backgroundSide; x++ {
+			c := y*backgroundSide + x
+			if isBackground(x, y) && counts[c] > 0 {
+				f.Background = append(f.Background, cells[c]/float64(counts[c]))
+			}
+		}
+	}
+
+	return f
+}
+
+func backgroundDifference(a, b []float64) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+	var s float64
+	for i := range a {
+		s += math.Abs(a[i] - b[i])
+	}
+	return s / float64(len(a))
+}
+
+// State keeps frames history of the camera.
+type State struct {
+	reference []float64
+	last      *Frame
+	identical int
+	changed   int
+}
+
+// Check checks frame against camera history and updates it. Black, white and
+// covered frames don't update the background reference.
+func (s *State) Check(f Frame, c Config) []Reason {
+	c.setDefaults()
+
+	var rs []Reason
+
+	if s.last != nil && s.last.Checksum == f.Checksum {
+		s.identical++
+	} else {
+		s.identical = 0
+	}
+
+	s.last = &f
+
+	if s.identical+1 >= c.FrozenFrames {
+		rs = append(rs, ReasonFrozen)
+	}
+
+	switch {
+	case f.Brightness < c.BlackLevel:
+		return append(rs, ReasonBlack)
+	case f.Brightness > c.WhiteLevel:
+		return append(rs, ReasonWhite)
+	case f.Contrast < c.MinContrast:
+		return append(rs, ReasonCovered)
+	}
+
+	if len(s.reference) != len(f.Background) {
+		s.reference = append([]float64(nil), f.Background...)
+		return rs
+	}
+
+	if backgroundDifference(s.reference, f.Background) >= c.ViewpointDifference {
+		s.changed++
+		if s.changed >= c.ViewpointFrames {
+			s.changed = 0
+			s.reference = append([]float64(nil), f.Background...)
+			rs = append(rs, ReasonViewpointChanged)
+		}
+		return rs
+	}
+
+	s.changed = 0
+
+	for i := range s.reference {
+		s.reference[i] += (f.Background[i] - s.reference[i]) * referenceWeight
+	}
+
+	return rs
+}
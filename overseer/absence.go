@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dimuls/oko/entity"
+)
+
+// absenceConfig configures reaction to a logged in session without face in
+// frame. Empty threshold disables the stage.
+type absenceConfig struct {
+	WarnAfter   string `yaml:"warn_after"`
+	LockAfter   string `yaml:"lock_after"`
+	LogoutAfter string `yaml:"logout_after"`
+
+	warnAfter   time.Duration
+	lockAfter   time.Duration
+	logoutAfter time.Duration
+}
+
+func parseOptionalDuration(name, value string, d *time.Duration) error {
+	if value == "" {
+		return nil
+	}
+	var err error
+	*d, err = time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("parse %s: %w", name, err)
+	}
+	return nil
+}
+
+func (c *absenceConfig) parse() error {
+	err := parseOptionalDuration("absence warn_after", c.WarnAfter, &c.warnAfter)
+	if err != nil {
+		return err
+	}
+
+	err = parseOptionalDuration("absence lock_after", c.LockAfter, &c.lockAfter)
+	if err != nil {
+		return err
+	}
+
+	return parseOptionalDuration("absence logout_after", c.LogoutAfter, &c.logoutAfter)
+}
+
+type absenceStage int
+
+const (
+	absenceNone absenceStage = iota
+	absenceWarned
+	absenceLocked
+	absenceLoggedOut
+)
+
+// absence tracks how long session of the user has had no face in frame.
+type absence struct {
+	user  string
+	since time.Time
+	stage absenceStage
+}
+
+func (st *hostState) resetAbsence() {
+	st.absence = absence{}
+}
+
+// handleAbsence is called when there is no face in frame of the user session.
+// Policy stages are applied once per absence, the latest reached stage wins.
+// Every stage raises incident.
+func (s *service) handleAbsence(h entity.Host, st *hostState, activeUser string) {
+	if st.absence.user != activeUser || st.absence.since.IsZero() {
+		st.absence = absence{user: activeUser, since: time.Now()}
+	}
+
+	c := s.config.hostGroup(h).Absence
+	absentFor := time.Since(st.absence.since)
+
+	reached := func(after time.Duration) bool {
+		return after > 0 && absentFor >= after
+	}
+
+	switch {
+	case reached(c.logoutAfter) && st.absence.stage < absenceLoggedOut:
+		st.absence.stage = absenceLoggedOut
+		s.raise(entity.Incident{
+			Type:      entity.IncidentUserAbsent,
+			HostName:  h.Name,
+			UserName:  activeUser,
+			Condition: "logout",
+			Message: fmt.Sprintf("[имя_хоста=%s, имя_пользователя=%s, отсутствует=%s] пользователь разлогинен из-за отсутствия",
+				h.Name, activeUser, absentFor.Round(time.Second)),
+		})
+		s.enforce(h, activeUser, actionLogout)
+
+	case reached(c.lockAfter) && st.absence.stage < absenceLocked:
+		st.absence.stage = absenceLocked
+		s.raise(entity.Incident{
+			Type:      entity.IncidentUserAbsent,
+			HostName:  h.Name,
+			UserName:  activeUser,
+			Condition: "lock",
+			Message: fmt.Sprintf("[имя_хоста=%s, имя_пользователя=%s, отсутствует=%s] рабочая станция заблокирована из-за отсутствия пользователя",
+				h.Name, activeUser, absentFor.Round(time.Second)),
+		})
+		s.enforce(h, activeUser, actionLock)
+
+	case reached(c.warnAfter) && st.absence.stage < absenceWarned:
+		st.absence.stage = absenceWarned
+		s.raise(entity.Incident{
+			Type:      entity.IncidentUserAbsent,
+			HostName:  h.Name,
+			UserName:  activeUser,
+			Condition: "warn",
+			Message: fmt.Sprintf("[имя_хоста=%s, имя_пользователя=%s, отсутствует=%s] рабочая станция оставлена без присмотра",
+				h.Name, activeUser, absentFor.Round(time.Second)),
+		})
+	}
+}
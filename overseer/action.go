@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/dimuls/oko/entity"
+	"github.com/dimuls/oko/overseer/notify"
+)
+
+type action string
+
+const (
+	actionIgnore action = "ignore"
+	actionNotify action = "notify"
+	actionLock   action = "lock"
+	actionLogout action = "logout"
+)
+
+func (a action) valid(allowed ...action) bool {
+	for _, aa := range allowed {
+		if a == aa {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAction validates action setting, empty action is replaced by default.
+func parseAction(name string, a *action, def action, allowed ...action) error {
+	if *a == "" {
+		*a = def
+		return nil
+	}
+	if !a.valid(allowed...) {
+		return fmt.Errorf("invalid %s %s", name, *a)
+	}
+	return nil
+}
+
+// enforce locks workstation or logs out current user according to action.
+func (s *service) enforce(h entity.Host, activeUser string, a action) {
+	if a == actionLock || a == actionLogout {
+		s.audit(string(a), h.Name, activeUser, nil)
+	}
+
+	switch a {
+	case actionLock:
+		err := h.LockWorkstation()
+		if err != nil {
+			eLog.Error(1, fmt.Sprintf("[имя_хоста=%s, имя_пользователя=%s] не удалось заблокировать рабочую станцию: %v", h.Name, activeUser, err))
+			s.notify(notify.Notification{
+				Type:      notificationActionFailed,
+				HostName:  h.Name,
+				UserName:  activeUser,
+				Condition: string(a),
+				Message:   fmt.Sprintf("[имя_хоста=%s, имя_пользователя=%s] не удалось заблокировать рабочую станцию", h.Name, activeUser),
+			})
+		}
+	case actionLogout:
+		err := h.LogoutCurrentUser()
+		if err != nil {
+			eLog.Error(1, fmt.Sprintf("[имя_хоста=%s, имя_пользователя=%s] не удалось разлогинить пользователя: %v", h.Name, activeUser, err))
+			s.notify(notify.Notification{
+				Type:      notificationActionFailed,
+				HostName:  h.Name,
+				UserName:  activeUser,
+				Condition: string(a),
+				Message:   fmt.Sprintf("[имя_хоста=%s, имя_пользователя=%s] не удалось разлогинить пользователя", h.Name, activeUser),
+			})
+		}
+	}
+}
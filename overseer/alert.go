@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dimuls/oko/entity"
+	"github.com/dimuls/oko/overseer/notify"
+)
+
+const (
+	defaultAlertingSuppressWindow   = "10m"
+	defaultAlertingReminderInterval = "1h"
+)
+
+// alertingConfig configures notifications deduplication. Identical
+// notifications are suppressed within suppress window. Alerts about ongoing
+// conditions fire once, are reminded every reminder interval and are followed
+// by recovery notification. Zero duration disables suppression or reminders.
+type alertingConfig struct {
+	SuppressWindow   string `yaml:"suppress_window"`
+	ReminderInterval string `yaml:"reminder_interval"`
+}
+
+type alertKey struct {
+	typ      string
+	hostName string
+}
+
+// alert is an ongoing condition, such as agent being offline.
+type alert struct {
+	since    time.Time
+	notified time.Time
+}
+
+// suppressed reports whether notification about the same condition was sent
+// within suppress window and remembers the notification otherwise. Message is
+// not compared, since it contains scores and durations. Notification without
+// condition and state can't be told from other ones of the same type, so it
+// is never suppressed.
+func (s *service) suppressed(n notify.Notification) bool {
+	if s.config.AlertingSuppressWindow == 0 || (n.Condition == "" && n.State == "") {
+		return false
+	}
+
+	key := fmt.Sprintf("%s|%s|%s|%s|%s", n.Type, n.State, n.HostName, n.UserName, n.Condition)
+
+	s.alertsMx.Lock()
+	defer s.alertsMx.Unlock()
+
+	for k, t := range s.sent {
+		if n.Time.Sub(t) >= s.config.AlertingSuppressWindow {
+			delete(s.sent, k)
+		}
+	}
+
+	if _, exists := s.sent[key]; exists {
+		return true
+	}
+
+	s.sent[key] = n.Time
+
+	return false
+}
+
+// mute disables notifications about the host for duration and returns time
+// they are enabled again.
+func (s *service) mute(hostName string, d time.Duration) time.Time {
+	until := time.Now().Add(d)
+
+	s.alertsMx.Lock()
+	s.mutes[hostName] = until
+	s.alertsMx.Unlock()
+
+	return until
+}
+
+func (s *service) unmute(hostName string) {
+	s.alertsMx.Lock()
+	delete(s.mutes, hostName)
+	s.alertsMx.Unlock()
+}
+
+// muted reports whether notifications about the host are disabled and until
+// when.
+func (s *service) muted(hostName string) (time.Time, bool) {
+	s.alertsMx.Lock()
+	defer s.alertsMx.Unlock()
+
+	until, exists := s.mutes[hostName]
+	if !exists {
+		return time.Time{}, false
+	}
+
+	if time.Now().After(until) {
+		delete(s.mutes, hostName)
+		return time.Time{}, false
+	}
+
+	return until, true
+}
+
+// alert notifies about ongoing condition on its first occurrence and reminds
+// about it every reminder interval.
+func (s *service) alert(n notify.Notification) {
+	key := alertKey{typ: n.Type, hostName: n.HostName}
+	now := time.Now()
+
+	s.alertsMx.Lock()
+
+	a, exists := s.alerts[key]
+	switch {
+	case !exists:
+		s.alerts[key] = &alert{since: now, notified: now}
+		n.State = notify.StateFiring
+	case s.config.AlertingReminderInterval > 0 && now.Sub(a.notified) >= s.config.AlertingReminderInterval:
+		a.notified = now
+		n.State = notify.StateReminder
+		n.Message = fmt.Sprintf("напоминание, продолжается %s: %s", now.Sub(a.since).Round(time.Second), n.Message)
+	default:
+		s.alertsMx.Unlock()
+		return
+	}
+
+	s.alertsMx.Unlock()
+
+	s.notify(n)
+}
+
+// resolve notifies about ongoing condition recovery if it was alerted.
+func (s *service) resolve(typ, hostName, message string) {
+	key := alertKey{typ: typ, hostName: hostName}
+
+	s.alertsMx.Lock()
+
+	a, exists := s.alerts[key]
+	if !exists {
+		s.alertsMx.Unlock()
+		return
+	}
+
+	delete(s.alerts, key)
+
+	s.alertsMx.Unlock()
+
+	s.notify(notify.Notification{
+		Type:     typ,
+		HostName: hostName,
+		State:    notify.StateRecovered,
+		Message:  fmt.Sprintf("%s, длилось %s", message, time.Since(a.since).Round(time.Second)),
+	})
+}
+
+// enter marks ongoing incident condition of the host, such as bystander
+// presence, and reports whether it has just begun. Incident is raised and
+// action is enforced only on the transition into the condition.
+func (s *service) enter(typ entity.IncidentType, hostName string) bool {
+	key := alertKey{typ: string(typ), hostName: hostName}
+
+	s.alertsMx.Lock()
+	defer s.alertsMx.Unlock()
+
+	if s.incidentStates[key] {
+		return false
+	}
+
+	s.incidentStates[key] = true
+
+	return true
+}
+
+// leave marks incident condition of the host as over and reports whether it
+// was ongoing.
+func (s *service) leave(typ entity.IncidentType, hostName string) bool {
+	key := alertKey{typ: string(typ), hostName: hostName}
+
+	s.alertsMx.Lock()
+	defer s.alertsMx.Unlock()
+
+	if !s.incidentStates[key] {
+		return false
+	}
+
+	delete(s.incidentStates, key)
+
+	return true
+}
+
+func (s *service) resolveRecognitionFailed(h entity.Host, activeUser string) {
+	s.resolve(notificationRecognitionFailed, h.Name,
+		fmt.Sprintf("[имя_хоста=%s, имя_пользователя=%s] распознавание снова работает", h.Name, activeUser))
+}
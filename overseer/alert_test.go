@@ -0,0 +1,121 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dimuls/oko/overseer/notify"
+)
+
+func TestSuppressed(t *testing.T) {
+	now := time.Now()
+
+	n := notify.Notification{
+		Type:      "unknown_person",
+		HostName:  "host",
+		UserName:  "ivan",
+		Condition: "logout",
+		Message:   "оценка=0.10",
+		Time:      now,
+	}
+
+	tests := []struct {
+		name   string
+		window time.Duration
+		// noCondition clears condition of the notifications.
+		noCondition bool
+		// prior is notification sent before.
+		prior func(n notify.Notification) notify.Notification
+		want  bool
+	}{
+		{
+			name:   "first notification",
+			window: time.Minute,
+			want:   false,
+		},
+		{
+			name:   "same condition within window",
+			window: time.Minute,
+			prior: func(p notify.Notification) notify.Notification {
+				p.Time = now.Add(-30 * time.Second)
+				p.Message = "оценка=0.20"
+				return p
+			},
+			want: true,
+		},
+		{
+			name:   "same condition after window",
+			window: time.Minute,
+			prior: func(p notify.Notification) notify.Notification {
+				p.Time = now.Add(-time.Minute)
+				return p
+			},
+			want: false,
+		},
+		{
+			name:   "other host",
+			window: time.Minute,
+			prior: func(p notify.Notification) notify.Notification {
+				p.HostName = "other"
+				return p
+			},
+			want: false,
+		},
+		{
+			name:   "other condition",
+			window: time.Minute,
+			prior: func(p notify.Notification) notify.Notification {
+				p.Condition = "lock"
+				return p
+			},
+			want: false,
+		},
+		{
+			name:   "other state",
+			window: time.Minute,
+			prior: func(p notify.Notification) notify.Notification {
+				p.State = notify.StateFiring
+				return p
+			},
+			want: false,
+		},
+		{
+			name:        "no condition",
+			window:      time.Minute,
+			noCondition: true,
+			prior: func(p notify.Notification) notify.Notification {
+				return p
+			},
+			want: false,
+		},
+		{
+			name: "suppression disabled",
+			prior: func(p notify.Notification) notify.Notification {
+				return p
+			},
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &service{sent: map[string]time.Time{}}
+			s.config.AlertingSuppressWindow = tt.window
+
+			n := n
+			if tt.noCondition {
+				n.Condition = ""
+			}
+
+			if tt.prior != nil {
+				if s.suppressed(tt.prior(n)) {
+					t.Fatal("prior notification is suppressed")
+				}
+			}
+
+			if got := s.suppressed(n); got != tt.want {
+				t.Errorf("suppressed = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
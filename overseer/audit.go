@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const defaultAuditLogPath = "audit.log"
+
+type auditRecord struct {
+	Time     time.Time              `json:"time"`
+	Event    string                 `json:"event"`
+	HostName string                 `json:"host_name,omitempty"`
+	UserName string                 `json:"user_name,omitempty"`
+	Details  map[string]interface{} `json:"details,omitempty"`
+}
+
+// auditLog appends JSON encoded records to file, one record per line.
+type auditLog struct {
+	file *os.File
+	mx   sync.Mutex
+}
+
+func openAuditLog(path string) (*auditLog, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0664)
+	if err != nil {
+		return nil, err
+	}
+	return &auditLog{file: f}, nil
+}
+
+func (l *auditLog) write(r auditRecord) error {
+	r.Time = time.Now()
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("JSON encode record: %w", err)
+	}
+
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	_, err = l.file.Write(append(data, '\n'))
+	return err
+}
+
+func (l *auditLog) Close() error {
+	return l.file.Close()
+}
+
+func (s *service) audit(event, hostName, userName string, details map[string]interface{}) {
+	err := s.auditLog.write(auditRecord{
+		Event:    event,
+		HostName: hostName,
+		UserName: userName,
+		Details:  details,
+	})
+	if err != nil {
+		eLog.Error(1, fmt.Sprintf("не удалось записать в журнал аудита: %v", err))
+	}
+}
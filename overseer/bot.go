@@ -0,0 +1,473 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/tucnak/telebot.v2"
+
+	"github.com/dimuls/oko/entity"
+)
+
+const (
+	botRetryPeriod   = time.Minute
+	botIncidentsShow = 10
+
+	// botMaxMessageLength is a maximal length of Telegram message in
+	// characters.
+	botMaxMessageLength = 4096
+)
+
+// telegramBotConfig configures Telegram bot for operators. Operators can use
+// all commands, viewers only commands which don't change anything. Bot token
+// and operators default to telegram_bot_token and
+// telegram_notifications_recipients. Disabled bot with token only tells users
+// their IDs.
+type telegramBotConfig struct {
+	Enabled   bool   `yaml:"enabled"`
+	Token     string `yaml:"token"`
+	Operators []int  `yaml:"operators"`
+	Viewers   []int  `yaml:"viewers"`
+}
+
+type botRole int
+
+const (
+	botRoleNone botRole = iota
+	botRoleViewer
+	botRoleOperator
+)
+
+func (r botRole) String() string {
+	switch r {
+	case botRoleOperator:
+		return "operator"
+	case botRoleViewer:
+		return "viewer"
+	}
+	return "none"
+}
+
+type botCommand struct {
+	name    string
+	args    string
+	descr   string
+	role    botRole
+	handler func(m *telebot.Message, args []string) (string, error)
+}
+
+// operatorBot serves operators commands. Bot is created in background since
+// its creation requires Telegram to be reachable.
+type operatorBot struct {
+	s      *service
+	config telegramBotConfig
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newOperatorBot(s *service, c telegramBotConfig) *operatorBot {
+	return &operatorBot{
+		s:      s,
+		config: c,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+func (b *operatorBot) role(userID int) botRole {
+	for _, id := range b.config.Operators {
+		if id == userID {
+			return botRoleOperator
+		}
+	}
+	for _, id := range b.config.Viewers {
+		if id == userID {
+			return botRoleViewer
+		}
+	}
+	return botRoleNone
+}
+
+func (b *operatorBot) start() {
+	go func() {
+		defer close(b.done)
+
+		var (
+			bot *telebot.Bot
+			err error
+		)
+
+		for {
+			bot, err = telebot.NewBot(telebot.Settings{
+				Token:  b.config.Token,
+				Client: &http.Client{Timeout: 30 * time.Second},
+				Poller: &telebot.LongPoller{
+					Timeout: 10 * time.Second,
+				},
+			})
+			if err == nil {
+				break
+			}
+
+			eLog.Error(1, fmt.Sprintf("не удалось создать телеграм бота: %v", err))
+
+			select {
+			case <-time.After(botRetryPeriod):
+			case <-b.stop:
+				return
+			}
+		}
+
+		b.handle(bot)
+
+		go func() {
+			<-b.stop
+			bot.Stop()
+		}()
+
+		bot.Start()
+	}()
+}
+
+func (b *operatorBot) close() {
+	close(b.stop)
+	<-b.done
+}
+
+func (b *operatorBot) commands() []botCommand {
+	return []botCommand{
+		{name: "/hosts", descr: "статусы хостов",
+			role: botRoleViewer, handler: b.hosts},
+		{name: "/status", args: "<хост>", descr: "подробный статус хоста",
+			role: botRoleViewer, handler: b.status},
+		{name: "/who", args: "<хост>", descr: "кто работает за хостом",
+			role: botRoleViewer, handler: b.who},
+		{name: "/incidents", descr: "последние инциденты",
+			role: botRoleViewer, handler: b.incidents},
+		{name: "/logout", args: "<хост>", descr: "разлогинить пользователя хоста",
+			role: botRoleOperator, handler: b.logout},
+		{name: "/mute", args: "<хост> <длительность>", descr: "отключить оповещения хоста",
+			role: botRoleOperator, handler: b.mute},
+		{name: "/unmute", args: "<хост>", descr: "включить оповещения хоста",
+			role: botRoleOperator, handler: b.unmute},
+	}
+}
+
+func (b *operatorBot) handle(bot *telebot.Bot) {
+	if !b.config.Enabled {
+		bot.Handle(telebot.OnText, func(m *telebot.Message) {
+			if m.Sender == nil {
+				return
+			}
+			b.send(bot, m.Sender, fmt.Sprintf("Ваш ID пользователя %d.", m.Sender.ID))
+		})
+		return
+	}
+
+	help := func(m *telebot.Message) {
+		if m.Sender == nil {
+			return
+		}
+
+		role := b.role(m.Sender.ID)
+
+		lines := []string{fmt.Sprintf("Ваш ID пользователя %d, роль %s.", m.Sender.ID, role)}
+
+		for _, c := range b.commands() {
+			if role >= c.role {
+				lines = append(lines, strings.TrimSpace(c.name+" "+c.args)+" — "+c.descr)
+			}
+		}
+
+		b.send(bot, m.Sender, strings.Join(lines, "\n"))
+	}
+
+	bot.Handle("/start", help)
+	bot.Handle("/help", help)
+	bot.Handle(telebot.OnText, help)
+
+	for _, c := range b.commands() {
+		c := c
+		bot.Handle(c.name, func(m *telebot.Message) {
+			if m.Sender == nil {
+				return
+			}
+			b.send(bot, m.Sender, b.execute(c, m))
+		})
+	}
+}
+
+// splitMessage splits text into messages not longer than Telegram allows,
+// preferably at line breaks.
+func splitMessage(text string) []string {
+	rs := []rune(text)
+
+	var ms []string
+
+	for len(rs) > botMaxMessageLength {
+		cut := botMaxMessageLength
+		for i := botMaxMessageLength; i > 0; i-- {
+			if rs[i] == '\n' {
+				cut = i
+				break
+			}
+		}
+
+		ms = append(ms, string(rs[:cut]))
+
+		rs = rs[cut:]
+		if rs[0] == '\n' {
+			rs = rs[1:]
+		}
+	}
+
+	return append(ms, string(rs))
+}
+
+// send sends text to the user splitting it into several messages if it is
+// too long.
+func (b *operatorBot) send(bot *telebot.Bot, u *telebot.User, text string) {
+	for _, m := range splitMessage(text) {
+		_, err := bot.Send(u, m)
+		if err != nil {
+			eLog.Error(1, fmt.Sprintf("[telegram_id=%d] не удалось отправить сообщение телеграм бота: %v", u.ID, err))
+			return
+		}
+	}
+}
+
+// execute checks sender's role, executes command and writes it to the audit
+// log.
+func (b *operatorBot) execute(c botCommand, m *telebot.Message) string {
+	role := b.role(m.Sender.ID)
+	args := strings.Fields(m.Payload)
+
+	var hostName string
+	if len(args) > 0 {
+		hostName = args[0]
+	}
+
+	details := map[string]interface{}{
+		"command":            c.name,
+		"args":               args,
+		"telegram_user_id":   m.Sender.ID,
+		"telegram_user_name": m.Sender.Username,
+		"role":               role.String(),
+	}
+
+	if role < c.role {
+		details["result"] = "denied"
+		b.s.audit("bot_command", hostName, "", details)
+		eLog.Warning(1, fmt.Sprintf("[telegram_id=%d, команда=%s] команда телеграм бота запрещена", m.Sender.ID, c.name))
+		return "Команда запрещена."
+	}
+
+	reply, err := c.handler(m, args)
+	if err != nil {
+		details["result"] = "failed"
+		details["error"] = err.Error()
+		b.s.audit("bot_command", hostName, "", details)
+		return "Ошибка: " + err.Error()
+	}
+
+	details["result"] = "done"
+	b.s.audit("bot_command", hostName, "", details)
+
+	return reply
+}
+
+func (b *operatorBot) host(args []string, argsCount int) (entity.Host, error) {
+	if len(args) != argsCount {
+		return entity.Host{}, fmt.Errorf("неверное число аргументов")
+	}
+
+	b.s.hostsMx.RLock()
+	h, exists := b.s.hosts[args[0]]
+	b.s.hostsMx.RUnlock()
+
+	if !exists {
+		return entity.Host{}, fmt.Errorf("хост %s не найден", args[0])
+	}
+
+	return h, nil
+}
+
+func (b *operatorBot) hostStatus(hostName string) (entity.HostStatus, bool) {
+	b.s.hostsStatusesMx.RLock()
+	defer b.s.hostsStatusesMx.RUnlock()
+
+	hs, exists := b.s.hostsStatuses[hostName]
+	return hs, exists
+}
+
+func (b *operatorBot) hosts(m *telebot.Message, args []string) (string, error) {
+	hss := b.s.HostsStatuses()
+	if len(hss) == 0 {
+		return "Нет статусов хостов.", nil
+	}
+
+	sort.Slice(hss, func(i, j int) bool {
+		return hss[i].Name < hss[j].Name
+	})
+
+	var lines []string
+
+	for _, hs := range hss {
+		state := string(hs.Outcome)
+		switch {
+		case !hs.Online:
+			state = "выключен"
+		case !hs.AgentOnline:
+			state = "агент выключен"
+		case hs.ActiveUser == "":
+			state = "нет пользователя"
+		}
+
+		line := fmt.Sprintf("%s: %s", hs.Name, state)
+		if hs.ActiveUser != "" {
+			line += ", " + hs.ActiveUser
+		}
+		if until, muted := b.s.muted(hs.Name); muted {
+			line += ", оповещения отключены до " + until.Format("15:04")
+		}
+
+		lines = append(lines, line)
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+func (b *operatorBot) status(m *telebot.Message, args []string) (string, error) {
+	h, err := b.host(args, 1)
+	if err != nil {
+		return "", err
+	}
+
+	hs, exists := b.hostStatus(h.Name)
+	if !exists {
+		return fmt.Sprintf("Хост %s ещё не обработан.", h.Name), nil
+	}
+
+	group := h.Group
+	if group == "" {
+		group = defaultHostGroup
+	}
+
+	lines := []string{
+		fmt.Sprintf("Хост: %s", hs.Name),
+		fmt.Sprintf("Группа: %s", group),
+		fmt.Sprintf("Включен: %t, агент включен: %t", hs.Online, hs.AgentOnline),
+		fmt.Sprintf("Пользователь: %s", hs.ActiveUser),
+		fmt.Sprintf("Результат: %s, оценка: %.2f, лиц: %d", hs.Outcome, hs.Score, hs.FacesCount),
+		fmt.Sprintf("Ошибок распознавания подряд: %d", hs.RecognitionErrors),
+		fmt.Sprintf("Обновлён: %s", hs.UpdatedAt.Format(time.RFC3339)),
+	}
+
+	if hs.Error != "" {
+		lines = append(lines, "Ошибка: "+hs.Error)
+	}
+
+	if until, muted := b.s.muted(h.Name); muted {
+		lines = append(lines, "Оповещения отключены до "+until.Format(time.RFC3339))
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+func (b *operatorBot) who(m *telebot.Message, args []string) (string, error) {
+	h, err := b.host(args, 1)
+	if err != nil {
+		return "", err
+	}
+
+	hs, exists := b.hostStatus(h.Name)
+	if !exists || hs.ActiveUser == "" {
+		return fmt.Sprintf("За хостом %s никто не залогинен.", h.Name), nil
+	}
+
+	recognized := "не распознан"
+	if hs.RecognizedUserID != "" {
+		recognized = b.s.userName(hs.RecognizedUserID)
+		if recognized == "" {
+			recognized = "неизвестный"
+		}
+		recognized = fmt.Sprintf("%s (оценка %.2f)", recognized, hs.Score)
+	}
+
+	return fmt.Sprintf("Залогинен: %s\nВ кадре: %s\nРезультат: %s", hs.ActiveUser, recognized, hs.Outcome), nil
+}
+
+func (b *operatorBot) incidents(m *telebot.Message, args []string) (string, error) {
+	is := b.s.Incidents()
+	if len(is) == 0 {
+		return "Нет инцидентов.", nil
+	}
+
+	if len(is) > botIncidentsShow {
+		is = is[len(is)-botIncidentsShow:]
+	}
+
+	var lines []string
+
+	for i := len(is) - 1; i >= 0; i-- {
+		lines = append(lines, fmt.Sprintf("%s %s", is[i].Time.Format("02.01 15:04"), is[i].Message))
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+func (b *operatorBot) logout(m *telebot.Message, args []string) (string, error) {
+	h, err := b.host(args, 1)
+	if err != nil {
+		return "", err
+	}
+
+	err = h.LogoutCurrentUser()
+	if err != nil {
+		return "", fmt.Errorf("не удалось разлогинить пользователя: %w", err)
+	}
+
+	var activeUser string
+	if hs, exists := b.hostStatus(h.Name); exists {
+		activeUser = hs.ActiveUser
+	}
+
+	eLog.Info(1, fmt.Sprintf("[имя_хоста=%s, имя_пользователя=%s, telegram_id=%d] пользователь разлогинен оператором", h.Name, activeUser, m.Sender.ID))
+
+	return fmt.Sprintf("Пользователь %s хоста %s разлогинен.", activeUser, h.Name), nil
+}
+
+func (b *operatorBot) mute(m *telebot.Message, args []string) (string, error) {
+	h, err := b.host(args, 2)
+	if err != nil {
+		return "", err
+	}
+
+	d, err := time.ParseDuration(args[1])
+	if err != nil || d <= 0 {
+		return "", fmt.Errorf("неверная длительность %s", args[1])
+	}
+
+	until := b.s.mute(h.Name, d)
+
+	eLog.Info(1, fmt.Sprintf("[имя_хоста=%s, telegram_id=%d] оповещения отключены до %s", h.Name, m.Sender.ID, until.Format(time.RFC3339)))
+
+	return fmt.Sprintf("Оповещения хоста %s отключены до %s.", h.Name, until.Format(time.RFC3339)), nil
+}
+
+func (b *operatorBot) unmute(m *telebot.Message, args []string) (string, error) {
+	h, err := b.host(args, 1)
+	if err != nil {
+		return "", err
+	}
+
+	b.s.unmute(h.Name)
+
+	eLog.Info(1, fmt.Sprintf("[имя_хоста=%s, telegram_id=%d] оповещения включены", h.Name, m.Sender.ID))
+
+	return fmt.Sprintf("Оповещения хоста %s включены.", h.Name), nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitMessage(t *testing.T) {
+	line := strings.Repeat("х", 100)
+
+	tests := []struct {
+		name string
+		text string
+		want []int
+	}{
+		{
+			name: "short",
+			text: "хост: authorized",
+			want: []int{16},
+		},
+		{
+			name: "max length",
+			text: strings.Repeat("х", botMaxMessageLength),
+			want: []int{botMaxMessageLength},
+		},
+		{
+			name: "split at line break",
+			text: strings.TrimSuffix(strings.Repeat(line+"\n", 50), "\n"),
+			want: []int{40*101 - 1, 10*101 - 1},
+		},
+		{
+			name: "long line",
+			text: strings.Repeat("х", botMaxMessageLength+10),
+			want: []int{botMaxMessageLength, 10},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ms := splitMessage(tt.text)
+
+			if len(ms) != len(tt.want) {
+				t.Fatalf("messages = %d, want %d", len(ms), len(tt.want))
+			}
+
+			for i, m := range ms {
+				if got := len([]rune(m)); got != tt.want[i] {
+					t.Errorf("message #%d length = %d, want %d", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dimuls/oko/entity"
+	"github.com/dimuls/oko/face"
+)
+
+// userName finds user name by face API user ID across all hosts.
+func (s *service) userName(userID string) string {
+	s.hostsMx.RLock()
+	defer s.hostsMx.RUnlock()
+
+	for _, h := range s.hosts {
+		for name, id := range h.Users {
+			if id == userID {
+				return name
+			}
+		}
+	}
+
+	return ""
+}
+
+// userID finds face API user ID of the user by name across all hosts.
+func (s *service) userID(userName string) string {
+	s.hostsMx.RLock()
+	defer s.hostsMx.RUnlock()
+
+	for _, h := range s.hosts {
+		if id, exists := h.Users[userName]; exists {
+			return id
+		}
+	}
+
+	return ""
+}
+
+func (s *service) bystanderAllowed(h entity.Host, userName string) bool {
+	for _, names := range [][]string{s.config.AllowedBystanders, h.AllowedBystanders} {
+		for _, n := range names {
+			if n == userName {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkBystanders raises incident and enforces bystander action when not
+// allowed bystanders appear in frame. Nothing is done while they stay.
+func (s *service) checkBystanders(h entity.Host, activeUser string, bystanders []face.Recognition, acceptance float64) {
+	if s.config.BystanderAction == actionIgnore {
+		return
+	}
+
+	var unallowed []string
+
+	for _, b := range bystanders {
+		name := "неизвестный"
+
+		best, _ := b.Best()
+		if best.Score >= acceptance {
+			if n := s.userName(best.UserID); n != "" {
+				if s.bystanderAllowed(h, n) {
+					continue
+				}
+				name = n
+			}
+		}
+
+		unallowed = append(unallowed, name)
+	}
+
+	if len(unallowed) == 0 {
+		if s.leave(entity.IncidentBystanderPresent, h.Name) {
+			eLog.Info(1, fmt.Sprintf("[имя_хоста=%s, имя_пользователя=%s] посторонние покинули кадр", h.Name, activeUser))
+		}
+		return
+	}
+
+	// Bystanders staying in frame are not raised and enforced every tick.
+	if !s.enter(entity.IncidentBystanderPresent, h.Name) {
+		return
+	}
+
+	s.raise(entity.Incident{
+		Type:      entity.IncidentBystanderPresent,
+		HostName:  h.Name,
+		UserName:  activeUser,
+		Condition: strings.Join(unallowed, ","),
+		Message: fmt.Sprintf("[имя_хоста=%s, имя_пользователя=%s, посторонние=%s] обнаружены посторонние в кадре",
+			h.Name, activeUser, strings.Join(unallowed, ", ")),
+	})
+
+	s.enforce(h, activeUser, s.config.BystanderAction)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dimuls/oko/entity"
+)
+
+const defaultCorrelationWindow = "2m"
+
+// correlationConfig configures looking for the same person or account on
+// several hosts at once. Host statuses updated within window are compared.
+type correlationConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Window  string `yaml:"window"`
+}
+
+// sighting is a confident recognition of a face on a host.
+type sighting struct {
+	hostName   string
+	activeUser string
+	faceID     string
+}
+
+func (s *service) sightings() []sighting {
+	hosts := map[string]entity.Host{}
+	for _, h := range s.Hosts() {
+		hosts[h.Name] = h
+	}
+
+	var ss []sighting
+
+	for _, st := range s.HostsStatuses() {
+		if st.ActiveUser == "" || st.RecognizedUserID == "" ||
+			time.Since(st.UpdatedAt) > s.config.CorrelationWindow {
+			continue
+		}
+
+		switch st.Outcome {
+		case entity.OutcomeAuthorized, entity.OutcomeUnauthorized, entity.OutcomeSpoof:
+		default:
+			continue
+		}
+
+		h, exists := hosts[st.Name]
+		if !exists {
+			continue
+		}
+
+		if acceptance, _ := s.config.thresholds(h); st.Score < acceptance {
+			continue
+		}
+
+		ss = append(ss, sighting{
+			hostName:   st.Name,
+			activeUser: st.ActiveUser,
+			faceID:     st.RecognizedUserID,
+		})
+	}
+
+	return ss
+}
+
+func (s *service) faceName(faceID string) string {
+	if n := s.userName(faceID); n != "" {
+		return n
+	}
+	return faceID
+}
+
+func sortedKeys(m map[string]bool) []string {
+	var ks []string
+	for k := range m {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	return ks
+}
+
+// correlate looks across hosts for the same face active on several hosts
+// and for the same account used by different faces. Incident is raised once
+// for every involved host when correlation appears, so host mutes, host group
+// routing and audit apply to it as to any other host incident.
+func (s *service) correlate() {
+	ss := s.sightings()
+
+	faceHosts := map[string]map[string]bool{}
+	accountHosts := map[string]map[string]bool{}
+	accountFaces := map[string]map[string]bool{}
+
+	for _, si := range ss {
+		if faceHosts[si.faceID] == nil {
+			faceHosts[si.faceID] = map[string]bool{}
+		}
+		faceHosts[si.faceID][si.hostName] = true
+
+		if accountHosts[si.activeUser] == nil {
+			accountHosts[si.activeUser] = map[string]bool{}
+			accountFaces[si.activeUser] = map[string]bool{}
+		}
+		accountHosts[si.activeUser][si.hostName] = true
+		accountFaces[si.activeUser][si.faceID] = true
+	}
+
+	var incidents []entity.Incident
+
+	active := map[string]bool{}
+
+	for faceID, hs := range faceHosts {
+		if len(hs) < 2 {
+			continue
+		}
+
+		hosts := sortedKeys(hs)
+		key := "face:" + faceID + ":" + strings.Join(hosts, ",")
+		active[key] = true
+
+		if s.correlations[key] {
+			continue
+		}
+
+		name := s.faceName(faceID)
+
+		for _, h := range hosts {
+			incidents = append(incidents, entity.Incident{
+				Type:      entity.IncidentFaceOnMultipleHosts,
+				HostName:  h,
+				UserName:  name,
+				Condition: key,
+				Message: fmt.Sprintf("[имя_хоста=%s, имена_хостов=%s, обнаруженный_пользователь=%s] один и тот же человек одновременно работает на нескольких хостах",
+					h, strings.Join(hosts, ", "), name),
+			})
+		}
+	}
+
+	for account, fs := range accountFaces {
+		if len(fs) < 2 || len(accountHosts[account]) < 2 {
+			continue
+		}
+
+		hosts := sortedKeys(accountHosts[account])
+
+		var names []string
+		for _, faceID := range sortedKeys(fs) {
+			names = append(names, s.faceName(faceID))
+		}
+
+		key := "account:" + account + ":" + strings.Join(hosts, ",") + ":" + strings.Join(sortedKeys(fs), ",")
+		active[key] = true
+
+		if s.correlations[key] {
+			continue
+		}
+
+		for _, h := range hosts {
+			incidents = append(incidents, entity.Incident{
+				Type:      entity.IncidentAccountMultipleFaces,
+				HostName:  h,
+				UserName:  account,
+				Condition: key,
+				Message: fmt.Sprintf("[имя_хоста=%s, имена_хостов=%s, имя_пользователя=%s, обнаруженные_пользователи=%s] под одной учётной записью на нескольких хостах работают разные люди",
+					h, strings.Join(hosts, ", "), account, strings.Join(names, ", ")),
+			})
+		}
+	}
+
+	s.correlations = active
+
+	for _, i := range incidents {
+		s.raise(i)
+	}
+}
@@ -0,0 +1,124 @@
+// +build !windows
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+func usage(errmsg string) {
+	fmt.Fprintf(os.Stderr,
+		"%s\n\n"+
+			"usage: %s [<command>] [--config-dir <path>] [<sink>]\n"+
+			"       where <command> is one of\n"+
+			"       run (default), install, remove or test_notifier.\n"+
+			"       test_notifier sends test notification to the <sink> or to all sinks.\n",
+		errmsg, os.Args[0])
+	os.Exit(2)
+}
+
+func main() {
+	const (
+		unitName  = "oko-overseer"
+		unitDescr = "Oko Overseer"
+	)
+
+	cmd := "run"
+	args := os.Args[1:]
+
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd = strings.ToLower(args[0])
+		args = args[1:]
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		log.Fatalf("failed to get executable path: %v", err)
+	}
+
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	configDirPath := fs.String("config-dir", filepath.Dir(exe),
+		"directory of the overseer.conf")
+	fs.Parse(args)
+
+	*configDirPath, err = filepath.Abs(*configDirPath)
+	if err != nil {
+		log.Fatalf("failed to get config directory absolute path: %v", err)
+	}
+
+	switch cmd {
+	case "run":
+		os.Exit(runDaemon(*configDirPath))
+	case "install":
+		err = installUnit(unitName, unitDescr, exe, *configDirPath)
+	case "remove":
+		err = removeUnit(unitName)
+	case "test_notifier":
+		err = testNotifiers(*configDirPath, fs.Arg(0))
+	default:
+		usage(fmt.Sprintf("invalid command %s", cmd))
+	}
+	if err != nil {
+		log.Fatalf("failed to %s %s: %v", cmd, unitName, err)
+	}
+}
+
+// runDaemon runs service in foreground until SIGINT or SIGTERM and returns
+// process exit code.
+func runDaemon(configDirPath string) int {
+	eLog = newJournalLog(os.Stdout)
+	defer eLog.Close()
+
+	eLog.Info(1, "запускаюсь")
+
+	s := &service{}
+
+	errno := s.start(configDirPath)
+	if errno != 0 {
+		return int(errno)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		s.run(ctx)
+	}()
+
+	err := sdNotify("READY=1")
+	if err != nil {
+		eLog.Warning(1, fmt.Sprintf("не удалось уведомить systemd о готовности: %v", err))
+	}
+
+	eLog.Info(1, "запущен")
+
+	sig := <-signals
+
+	eLog.Info(1, fmt.Sprintf("получен сигнал %s, останавливаю", sig))
+
+	sdNotify("STOPPING=1")
+
+	cancel()
+	<-done
+
+	s.stop()
+
+	eLog.Info(1, "остановлен")
+
+	return 0
+}
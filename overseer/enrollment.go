@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dimuls/oko/enrollment"
+	"github.com/dimuls/oko/entity"
+	"github.com/dimuls/oko/face"
+)
+
+const (
+	defaultAdaptiveEnrollmentDirectoryPath    = "enrollment"
+	defaultAdaptiveEnrollmentMinScore         = 0.95
+	defaultAdaptiveEnrollmentInterval         = "24h"
+	defaultAdaptiveEnrollmentMaxPhotosPerUser = 10
+
+	// adaptiveEnrollmentRetryInterval is a delay before the next attempt
+	// after frame failed quality check.
+	adaptiveEnrollmentRetryInterval = 10 * time.Minute
+)
+
+// adaptiveEnrollmentConfig configures collection of frames recognized with
+// very high confidence for enrollment. Collected frames wait for review in
+// users utility unless auto approve is set.
+type adaptiveEnrollmentConfig struct {
+	Enabled          bool               `yaml:"enabled"`
+	DirectoryPath    string             `yaml:"directory_path"`
+	MinScore         float64            `yaml:"min_score"`
+	Interval         string             `yaml:"interval"`
+	MaxPhotosPerUser int                `yaml:"max_photos_per_user"`
+	AutoApprove      bool               `yaml:"auto_approve"`
+	Quality          face.QualityConfig `yaml:"quality"`
+}
+
+// nextEnrollment returns time after which photo of the user can be collected.
+// Caller must hold enrollmentMx.
+func (s *service) nextEnrollment(userID string) (time.Time, error) {
+	if next, exists := s.enrollmentNext[userID]; exists {
+		return next, nil
+	}
+
+	rs, err := s.enrollment.Records(userID)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var next time.Time
+
+	if len(rs) > 0 {
+		next = rs[len(rs)-1].CreatedAt.Add(s.config.AdaptiveEnrollmentInterval)
+	}
+
+	s.enrollmentNext[userID] = next
+
+	return next, nil
+}
+
+// collectEnrollmentPhoto stores frame of the confidently recognized user for
+// enrollment if it passes quality check and user's caps allow it. Photo is
+// added to the user in background if auto approve is set, so slow face API
+// doesn't delay hosts processing.
+func (s *service) collectEnrollmentPhoto(ctx context.Context, h entity.Host, activeUser, userID string, score float64, frame []byte) {
+	r, ok := s.storeEnrollmentPhoto(h, activeUser, userID, score, frame)
+	if !ok {
+		return
+	}
+
+	s.audit("enrollment_photo_collected", h.Name, activeUser, map[string]interface{}{
+		"record_id": r.ID,
+		"user_id":   userID,
+		"score":     score,
+	})
+
+	if !s.config.AdaptiveEnrollment.AutoApprove {
+		eLog.Info(1, fmt.Sprintf("[имя_хоста=%s, имя_пользователя=%s, идентификатор_записи=%s] фотография для дообучения ожидает проверки", h.Name, activeUser, r.ID))
+		return
+	}
+
+	s.enrollmentWg.Add(1)
+	go func() {
+		defer s.enrollmentWg.Done()
+		s.approveEnrollmentPhoto(ctx, h, activeUser, userID, r.ID)
+	}()
+}
+
+// storeEnrollmentPhoto creates enrollment record with the frame if it is time
+// to collect photo of the user.
+func (s *service) storeEnrollmentPhoto(h entity.Host, activeUser, userID string, score float64, frame []byte) (enrollment.Record, bool) {
+	c := s.config.AdaptiveEnrollment
+
+	if score < c.MinScore {
+		return enrollment.Record{}, false
+	}
+
+	s.enrollmentMx.Lock()
+	defer s.enrollmentMx.Unlock()
+
+	next, err := s.nextEnrollment(userID)
+	if err != nil {
+		eLog.Error(1, fmt.Sprintf("[имя_хоста=%s, имя_пользователя=%s] не удалось получить фотографии пользователя для дообучения: %v", h.Name, activeUser, err))
+		return enrollment.Record{}, false
+	}
+
+	if time.Now().Before(next) {
+		return enrollment.Record{}, false
+	}
+
+	rs, err := s.enrollment.Records(userID)
+	if err != nil {
+		eLog.Error(1, fmt.Sprintf("[имя_хоста=%s, имя_пользователя=%s] не удалось получить фотографии пользователя для дообучения: %v", h.Name, activeUser, err))
+		return enrollment.Record{}, false
+	}
+
+	collected := 0
+	for _, r := range rs {
+		if r.Status == enrollment.StatusPending || r.Status == enrollment.StatusAdded {
+			collected++
+		}
+	}
+
+	if collected >= c.MaxPhotosPerUser {
+		s.enrollmentNext[userID] = time.Now().Add(s.config.AdaptiveEnrollmentInterval)
+		return enrollment.Record{}, false
+	}
+
+	_, q, err := face.CheckQuality(frame, c.Quality)
+	if err != nil || !q.OK(true) {
+		s.enrollmentNext[userID] = time.Now().Add(adaptiveEnrollmentRetryInterval)
+		return enrollment.Record{}, false
+	}
+
+	r, err := s.enrollment.Create(enrollment.Record{
+		HostName: h.Name,
+		UserName: activeUser,
+		UserID:   userID,
+		Score:    score,
+		Quality:  q,
+	}, frame)
+	if err != nil {
+		eLog.Error(1, fmt.Sprintf("[имя_хоста=%s, имя_пользователя=%s] не удалось сохранить фотографию для дообучения: %v", h.Name, activeUser, err))
+		return enrollment.Record{}, false
+	}
+
+	s.enrollmentNext[userID] = time.Now().Add(s.config.AdaptiveEnrollmentInterval)
+
+	return r, true
+}
+
+// approveEnrollmentPhoto adds photo of the collected record to the user.
+func (s *service) approveEnrollmentPhoto(ctx context.Context, h entity.Host, activeUser, userID, recordID string) {
+	r, err := s.enrollment.Approve(ctx, s.faceEnroller, recordID)
+	if err != nil {
+		eLog.Error(1, fmt.Sprintf("[имя_хоста=%s, имя_пользователя=%s, идентификатор_записи=%s] не удалось добавить фотографию для дообучения: %v", h.Name, activeUser, recordID, err))
+		return
+	}
+
+	eLog.Info(1, fmt.Sprintf("[имя_хоста=%s, имя_пользователя=%s, идентификатор_записи=%s] фотография добавлена пользователю", h.Name, activeUser, r.ID))
+
+	s.audit("enrollment_photo_added", h.Name, activeUser, map[string]interface{}{
+		"record_id": r.ID,
+		"user_id":   userID,
+		"photo_id":  r.PhotoID,
+	})
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+
+	"github.com/dimuls/oko/face"
+)
+
+const (
+	defaultEvidenceJPEGQuality = 85
+
+	evidenceBoxWidth      = 3
+	evidencePixelateCells = 8
+)
+
+var (
+	evidenceSubjectColor = color.RGBA{R: 255, A: 255}
+	evidenceOtherColor   = color.RGBA{R: 255, G: 200, A: 255}
+)
+
+// evidenceConfig configures camera frame attached to unauthorized user
+// notifications. Face of the person in front of camera is boxed, other faces
+// can be pixelated to protect bystanders privacy.
+type evidenceConfig struct {
+	Enabled        bool `yaml:"enabled"`
+	DrawBoxes      bool `yaml:"draw_boxes"`
+	BlurOtherFaces bool `yaml:"blur_other_faces"`
+	JPEGQuality    int  `yaml:"jpeg_quality"`
+}
+
+func drawBox(img *image.RGBA, r image.Rectangle, c color.Color) {
+	r = r.Intersect(img.Bounds())
+	if r.Empty() {
+		return
+	}
+
+	u := image.NewUniform(c)
+
+	for _, side := range []image.Rectangle{
+		image.Rect(r.Min.X, r.Min.Y, r.Max.X, r.Min.Y+evidenceBoxWidth),
+		image.Rect(r.Min.X, r.Max.Y-evidenceBoxWidth, r.Max.X, r.Max.Y),
+		image.Rect(r.Min.X, r.Min.Y, r.Min.X+evidenceBoxWidth, r.Max.Y),
+		image.Rect(r.Max.X-evidenceBoxWidth, r.Min.Y, r.Max.X, r.Max.Y),
+	} {
+		draw.Draw(img, side.Intersect(r), u, image.Point{}, draw.Src)
+	}
+}
+
+// pixelate replaces rectangle with coarse grid of its mean colors, so face
+// can't be recognized.
+func pixelate(img *image.RGBA, r image.Rectangle) {
+	r = r.Intersect(img.Bounds())
+	if r.Empty() {
+		return
+	}
+
+	cell := r.Dx() / evidencePixelateCells
+	if cell < 4 {
+		cell = 4
+	}
+
+	for y := r.Min.Y; y < r.Max.Y; y += cell {
+		for x := r.Min.X; x < r.Max.X; x += cell {
+			c := image.Rect(x, y, x+cell, y+cell).Intersect(r)
+
+			var sr, sg, sb, n int
+
+			for cy := c.Min.Y; cy < c.Max.Y; cy++ {
+				for cx := c.Min.X; cx < c.Max.X; cx++ {
+					p := img.RGBAAt(cx, cy)
+					sr += int(p.R)
+					sg += int(p.G)
+					sb += int(p.B)
+					n++
+				}
+			}
+
+			mean := color.RGBA{R: uint8(sr / n), G: uint8(sg / n), B: uint8(sb / n), A: 255}
+
+			draw.Draw(img, c, image.NewUniform(mean), image.Point{}, draw.Src)
+		}
+	}
+}
+
+// evidence renders JPEG encoded frame for notification. The first
+// recognition is the person in front of camera.
+func (c evidenceConfig) evidence(img image.Image, rs []face.Recognition) ([]byte, error) {
+	b := img.Bounds()
+	rgba := image.NewRGBA(b)
+	draw.Draw(rgba, b, img, b.Min, draw.Src)
+
+	if c.BlurOtherFaces && len(rs) > 1 {
+		for _, r := range rs[1:] {
+			pixelate(rgba, r.Box)
+		}
+	}
+
+	if c.DrawBoxes {
+		for i, r := range rs {
+			if i == 0 {
+				drawBox(rgba, r.Box, evidenceSubjectColor)
+			} else {
+				drawBox(rgba, r.Box, evidenceOtherColor)
+			}
+		}
+	}
+
+	quality := c.JPEGQuality
+	if quality == 0 {
+		quality = defaultEvidenceJPEGQuality
+	}
+
+	var buf bytes.Buffer
+
+	err := jpeg.Encode(&buf, rgba, &jpeg.Options{Quality: quality})
+	if err != nil {
+		return nil, fmt.Errorf("JPEG encode: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
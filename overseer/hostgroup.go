@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/dimuls/oko/entity"
+)
+
+const (
+	defaultHostGroup = "default"
+
+	defaultRecognitionErrorThreshold = 3
+)
+
+type errorPolicy string
+
+const (
+	errorPolicyFailOpen   errorPolicy = "fail_open"
+	errorPolicyFailClosed errorPolicy = "fail_closed"
+	errorPolicyFailAfter  errorPolicy = "fail_after"
+)
+
+type hostGroupConfig struct {
+	RecognitionErrorPolicy    errorPolicy `yaml:"recognition_error_policy"`
+	RecognitionErrorThreshold int         `yaml:"recognition_error_threshold"`
+
+	Absence absenceConfig `yaml:"absence"`
+	Strikes strikesConfig `yaml:"strikes"`
+
+	CredentialSharingAction action `yaml:"credential_sharing_action"`
+	UnknownPersonAction     action `yaml:"unknown_person_action"`
+	NotAllowedUserAction    action `yaml:"not_allowed_user_action"`
+}
+
+func (c *hostGroupConfig) setDefaults() error {
+	switch c.RecognitionErrorPolicy {
+	case "":
+		c.RecognitionErrorPolicy = errorPolicyFailOpen
+	case errorPolicyFailOpen, errorPolicyFailClosed, errorPolicyFailAfter:
+	default:
+		return fmt.Errorf("invalid recognition_error_policy %s", c.RecognitionErrorPolicy)
+	}
+
+	if c.RecognitionErrorThreshold == 0 {
+		c.RecognitionErrorThreshold = defaultRecognitionErrorThreshold
+	}
+
+	err := parseAction("credential_sharing_action", &c.CredentialSharingAction, actionLogout,
+		actionNotify, actionLock, actionLogout)
+	if err != nil {
+		return err
+	}
+
+	err = parseAction("unknown_person_action", &c.UnknownPersonAction, actionLogout,
+		actionNotify, actionLock, actionLogout)
+	if err != nil {
+		return err
+	}
+
+	err = parseAction("not_allowed_user_action", &c.NotAllowedUserAction, actionLogout,
+		actionNotify, actionLock, actionLogout)
+	if err != nil {
+		return err
+	}
+
+	err = c.Strikes.setDefaults()
+	if err != nil {
+		return err
+	}
+
+	return c.Absence.parse()
+}
+
+// hostGroup returns config of the host group falling back to the default
+// group.
+func (c *serviceConfig) hostGroup(h entity.Host) hostGroupConfig {
+	if hg, exists := c.HostGroups[h.Group]; exists {
+		return hg
+	}
+	return c.HostGroups[defaultHostGroup]
+}
+
+const (
+	errorDecisionKeepSession = "keep_session"
+	errorDecisionLogout      = "logout"
+	errorDecisionPause       = "pause_recognition"
+)
+
+// recognitionErrorDecision decides whether to keep session or to logout user
+// after given number of consecutive recognition errors.
+func (hg hostGroupConfig) recognitionErrorDecision(errorsCount int) string {
+	switch hg.RecognitionErrorPolicy {
+	case errorPolicyFailClosed:
+		return errorDecisionLogout
+	case errorPolicyFailAfter:
+		if errorsCount >= hg.RecognitionErrorThreshold {
+			return errorDecisionLogout
+		}
+	}
+	return errorDecisionKeepSession
+}
@@ -0,0 +1,33 @@
+package main
+
+import "github.com/dimuls/oko/face/tamper"
+
+// hostState is a state which is kept between host processings. Host is
+// processed by one goroutine at a time, so state fields don't need locking.
+type hostState struct {
+	recognitionErrors int
+
+	verified         *verifiedResult
+	frameReuseHits   int
+	frameReuseMisses int
+
+	camera         tamper.State
+	cameraTampered []tamper.Reason
+
+	absence  absence
+	strikes  strikes
+	liveness livenessResult
+}
+
+func (s *service) hostState(hostName string) *hostState {
+	s.hostsStatesMx.Lock()
+	defer s.hostsStatesMx.Unlock()
+
+	st, exists := s.hostsStates[hostName]
+	if !exists {
+		st = &hostState{}
+		s.hostsStates[hostName] = st
+	}
+
+	return st
+}
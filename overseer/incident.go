@@ -0,0 +1,50 @@
+package main
+
+import (
+	"time"
+
+	"github.com/dimuls/oko/entity"
+	"github.com/dimuls/oko/overseer/notify"
+)
+
+const maxIncidents = 100
+
+func (s *service) raise(i entity.Incident) {
+	s.raiseWithPhoto(i, nil)
+}
+
+// raiseWithPhoto raises incident and attaches JPEG encoded photo to its
+// notification.
+func (s *service) raiseWithPhoto(i entity.Incident, photo []byte) {
+	i.Time = time.Now()
+
+	eLog.Warning(1, i.Message)
+
+	s.incidentsMx.Lock()
+	s.incidents = append(s.incidents, i)
+	if len(s.incidents) > maxIncidents {
+		s.incidents = s.incidents[len(s.incidents)-maxIncidents:]
+	}
+	s.incidentsMx.Unlock()
+
+	s.audit("incident", i.HostName, i.UserName, map[string]interface{}{
+		"type":    i.Type,
+		"message": i.Message,
+	})
+
+	s.notify(notify.Notification{
+		Type:      string(i.Type),
+		HostName:  i.HostName,
+		UserName:  i.UserName,
+		Condition: i.Condition,
+		Message:   i.Message,
+		Photo:     photo,
+	})
+}
+
+func (s *service) Incidents() []entity.Incident {
+	s.incidentsMx.RLock()
+	defer s.incidentsMx.RUnlock()
+
+	return append([]entity.Incident(nil), s.incidents...)
+}
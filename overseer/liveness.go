@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	"strings"
+	"time"
+
+	"github.com/dimuls/oko/entity"
+	"github.com/dimuls/oko/face/liveness"
+)
+
+const (
+	defaultLivenessFrames        = 5
+	defaultLivenessInterval      = "200ms"
+	defaultLivenessCheckInterval = "5m"
+)
+
+// livenessConfig configures liveness check. Interval is an interval between
+// frames of the burst. Check is made when active user changes and every
+// check interval.
+type livenessConfig struct {
+	Enabled         bool   `yaml:"enabled"`
+	Frames          int    `yaml:"frames"`
+	Interval        string `yaml:"interval"`
+	CheckInterval   string `yaml:"check_interval"`
+	Action          action `yaml:"action"`
+	liveness.Config `yaml:",inline"`
+}
+
+// livenessResult is a result of the last liveness check of the host.
+type livenessResult struct {
+	user      string
+	live      bool
+	checkedAt time.Time
+}
+
+// checkLiveness requests burst of frames from the agent and checks that the
+// face in front of camera is live. Result of the last check is returned
+// until active user changes or check interval passes. Spoof suspicion is
+// raised as incident and action is enforced once when it appears.
+func (s *service) checkLiveness(h entity.Host, st *hostState, activeUser string, box image.Rectangle) (bool, error) {
+	last := st.liveness
+
+	if last.user == activeUser && time.Since(last.checkedAt) < s.config.LivenessCheckInterval {
+		return last.live, nil
+	}
+
+	if last.user != activeUser {
+		s.leave(entity.IncidentSpoofSuspected, h.Name)
+	}
+
+	live, err := s.livenessBurst(h, activeUser, box)
+	if err != nil {
+		return false, err
+	}
+
+	st.liveness = livenessResult{user: activeUser, live: live, checkedAt: time.Now()}
+
+	return live, nil
+}
+
+// livenessOutcome returns outcome of the authorized user whose face is checked
+// for liveness if it is enabled. User stays authorized if check fails.
+func (s *service) livenessOutcome(h entity.Host, st *hostState, activeUser string, box image.Rectangle) (entity.RecognitionOutcome, error) {
+	if !s.config.Liveness.Enabled {
+		return entity.OutcomeAuthorized, nil
+	}
+
+	live, err := s.checkLiveness(h, st, activeUser, box)
+	if err != nil {
+		eLog.Error(1, fmt.Sprintf("[имя_хоста=%s, имя_пользователя=%s] не удалось проверить живость лица: %v", h.Name, activeUser, err))
+		return entity.OutcomeAuthorized, err
+	}
+
+	if !live {
+		return entity.OutcomeSpoof, nil
+	}
+
+	return entity.OutcomeAuthorized, nil
+}
+
+func (s *service) livenessBurst(h entity.Host, activeUser string, box image.Rectangle) (bool, error) {
+	frames, err := h.Frames(s.config.Liveness.Frames, s.config.LivenessInterval)
+	if err != nil {
+		return false, fmt.Errorf("get frames: %w", err)
+	}
+
+	var imgs []image.Image
+
+	for _, f := range frames {
+		img, _, err := image.Decode(bytes.NewReader(f))
+		if err != nil {
+			return false, fmt.Errorf("decode frame: %w", err)
+		}
+		imgs = append(imgs, img)
+	}
+
+	r := liveness.Check(imgs, box, s.config.Liveness.Config)
+	if r.Live {
+		if s.leave(entity.IncidentSpoofSuspected, h.Name) {
+			eLog.Info(1, fmt.Sprintf("[имя_хоста=%s, имя_пользователя=%s] лицо снова живое", h.Name, activeUser))
+		}
+		return true, nil
+	}
+
+	if !s.enter(entity.IncidentSpoofSuspected, h.Name) {
+		return false, nil
+	}
+
+	var reasons []string
+	for _, rr := range r.Reasons {
+		reasons = append(reasons, string(rr))
+	}
+
+	s.raise(entity.Incident{
+		Type:      entity.IncidentSpoofSuspected,
+		HostName:  h.Name,
+		UserName:  activeUser,
+		Condition: strings.Join(reasons, ","),
+		Message: fmt.Sprintf("[имя_хоста=%s, имя_пользователя=%s, причины=%s] подозрение на подмену лица",
+			h.Name, activeUser, strings.Join(reasons, ", ")),
+	})
+
+	s.enforce(h, activeUser, s.config.Liveness.Action)
+
+	return false, nil
+}
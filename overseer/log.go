@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// logger is a service log. It is implemented by windows event log and by
+// stdout log of the daemon.
+type logger interface {
+	Close() error
+	Info(eid uint32, msg string) error
+	Warning(eid uint32, msg string) error
+	Error(eid uint32, msg string) error
+}
+
+var eLog logger
+
+// journalLog writes messages one per line with syslog priority prefixes,
+// which journald understands when daemon output is connected to it.
+type journalLog struct {
+	w  io.Writer
+	mx sync.Mutex
+}
+
+func newJournalLog(w io.Writer) *journalLog {
+	return &journalLog{w: w}
+}
+
+func (l *journalLog) write(priority int, eid uint32, msg string) error {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	_, err := fmt.Fprintf(l.w, "<%d>%s\n", priority, msg)
+	return err
+}
+
+func (l *journalLog) Close() error {
+	return nil
+}
+
+func (l *journalLog) Info(eid uint32, msg string) error {
+	return l.write(6, eid, msg)
+}
+
+func (l *journalLog) Warning(eid uint32, msg string) error {
+	return l.write(4, eid, msg)
+}
+
+func (l *journalLog) Error(eid uint32, msg string) error {
+	return l.write(3, eid, msg)
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"golang.org/x/sys/windows/svc"
@@ -14,9 +15,10 @@ import (
 func usage(errmsg string) {
 	fmt.Fprintf(os.Stderr,
 		"%s\n\n"+
-			"usage: %s <command>\n"+
+			"usage: %s <command> [<sink>]\n"+
 			"       where <command> is one of\n"+
-			"       install, remove, debug, start, stop, pause or continue.\n",
+			"       install, remove, debug, start, stop, pause, continue or test_notifier.\n"+
+			"       test_notifier sends test notification to the <sink> or to all sinks.\n",
 		errmsg, os.Args[0])
 	os.Exit(2)
 }
@@ -57,6 +59,16 @@ func main() {
 		err = controlService(svcName, svc.Pause, svc.Paused)
 	case "continue":
 		err = controlService(svcName, svc.Continue, svc.Running)
+	case "test_notifier":
+		var exe, sinkName string
+		exe, err = exePath()
+		if err != nil {
+			break
+		}
+		if len(os.Args) > 2 {
+			sinkName = os.Args[2]
+		}
+		err = testNotifiers(filepath.Dir(exe), sinkName)
 	default:
 		usage(fmt.Sprintf("invalid command %s", cmd))
 	}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"image"
+
+	"github.com/dimuls/oko/entity"
+	"github.com/dimuls/oko/face"
+)
+
+// handleMismatch raises incident about person in front of camera who is not
+// the logged in user and enforces host group policy. Different enrolled user
+// means credential sharing, account owner means they aren't allowed to work on
+// the host, otherwise person is unknown. Camera frame is attached to the
+// incident notification as evidence if enabled.
+func (s *service) handleMismatch(h entity.Host, st *hostState, activeUser string, kind strikeKind, recognizedUserID string, score float64,
+	img image.Image, recognitions []face.Recognition) {
+	hg := s.config.hostGroup(h)
+
+	i := entity.Incident{
+		HostName: h.Name,
+		UserName: activeUser,
+	}
+
+	var a action
+
+	switch kind {
+	case strikeDifferentUser:
+		name := s.userName(recognizedUserID)
+		if name == "" {
+			name = recognizedUserID
+		}
+		a = hg.CredentialSharingAction
+		i.Type = entity.IncidentCredentialSharing
+		i.Condition = recognizedUserID
+		i.Message = fmt.Sprintf("[имя_хоста=%s, имя_пользователя=%s, обнаруженный_пользователь=%s, оценка=%.2f, действие=%s] под учётной записью пользователя работает %s",
+			h.Name, activeUser, name, score, a, name)
+	case strikeNotAllowedUser:
+		a = hg.NotAllowedUserAction
+		i.Type = entity.IncidentUserNotAllowed
+		i.Condition = string(kind)
+		i.Message = fmt.Sprintf("[имя_хоста=%s, имя_пользователя=%s, оценка=%.2f, действие=%s] пользователю не разрешено работать на хосте",
+			h.Name, activeUser, score, a)
+	default:
+		a = hg.UnknownPersonAction
+		i.Type = entity.IncidentUnknownPerson
+		i.Condition = string(kind)
+		i.Message = fmt.Sprintf("[имя_хоста=%s, имя_пользователя=%s, оценка=%.2f, действие=%s] под учётной записью пользователя работает неизвестный человек",
+			h.Name, activeUser, score, a)
+	}
+
+	var photo []byte
+
+	if s.config.Evidence.Enabled && img != nil {
+		var err error
+		photo, err = s.config.Evidence.evidence(img, recognitions)
+		if err != nil {
+			eLog.Error(1, fmt.Sprintf("[имя_хоста=%s, имя_пользователя=%s] не удалось подготовить кадр для оповещения: %v", h.Name, activeUser, err))
+		}
+	}
+
+	s.raiseWithPhoto(i, photo)
+
+	s.audit(string(i.Type), h.Name, activeUser, map[string]interface{}{
+		"recognized_user_id": recognizedUserID,
+		"score":              score,
+		"strikes":            st.strikesCount(kind),
+		"action":             a,
+	})
+
+	s.enforce(h, activeUser, a)
+
+	// Next incident requires new strikes, so notify action doesn't repeat
+	// every tick.
+	delete(st.strikes.times, kind)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/dimuls/oko/overseer/notify"
+)
+
+// Notification types of overseer events. Incidents are notified with their
+// incident types.
+const (
+	notificationAgentOffline       = "agent_offline"
+	notificationAgentStatusFailed  = "agent_status_failed"
+	notificationActionFailed       = "action_failed"
+	notificationRecognitionFailed  = "recognition_failed"
+	notificationRecognitionPaused  = "recognition_paused"
+	notificationRecognitionResumed = "recognition_resumed"
+	notificationFaceAPIDegraded    = "face_api_degraded"
+)
+
+const (
+	defaultNotificationSendTimeout = 30 * time.Second
+	defaultOutboxDirectoryPath     = "outbox"
+
+	legacyTelegramSinkName = "telegram"
+)
+
+// notifierSinksConfigs returns configured notifier sinks. Legacy telegram
+// settings are used when no sinks are configured.
+func (c *serviceConfig) notifierSinksConfigs() []notify.SinkConfig {
+	if len(c.Notifiers) > 0 || c.TelegramBotToken == "" {
+		return c.Notifiers
+	}
+
+	return []notify.SinkConfig{{
+		Name: legacyTelegramSinkName,
+		Type: "telegram",
+		Params: map[string]interface{}{
+			"bot_token":  c.TelegramBotToken,
+			"recipients": c.TelegramNotificationsRecipients,
+		},
+	}}
+}
+
+// hostGroupName returns group name of the host used by notification routing.
+func (s *service) hostGroupName(hostName string) string {
+	s.hostsMx.RLock()
+	defer s.hostsMx.RUnlock()
+
+	h, exists := s.hosts[hostName]
+	if !exists {
+		return ""
+	}
+
+	if _, exists := s.config.HostGroups[h.Group]; !exists {
+		return defaultHostGroup
+	}
+
+	return h.Group
+}
+
+// notify queues notification for delivery unless identical notification
+// was sent recently.
+func (s *service) notify(n notify.Notification) {
+	n.Time = time.Now()
+
+	if _, muted := s.muted(n.HostName); muted {
+		eLog.Info(1, fmt.Sprintf("[имя_хоста=%s, тип=%s] оповещения хоста отключены", n.HostName, n.Type))
+		return
+	}
+
+	if s.suppressed(n) {
+		return
+	}
+
+	if n.HostName != "" && n.HostGroup == "" {
+		n.HostGroup = s.hostGroupName(n.HostName)
+	}
+
+	err := s.outbox.Enqueue(n)
+	if err != nil {
+		eLog.Error(1, fmt.Sprintf("[тип=%s] не удалось поставить оповещение в очередь: %v", n.Type, err))
+	}
+}
+
+func (s *service) deliveryFailed(d notify.Delivery, err error) {
+	eLog.Error(1, fmt.Sprintf("[получатель=%s, адресат=%s, тип=%s, попытка=%d, статус=%s] не удалось отправить оповещение: %v",
+		d.Sink, d.Recipient, d.Notification.Type, d.Attempts, d.Status, err))
+}
+
+// NotificationDeliveries returns deliveries without photos to keep response
+// small.
+func (s *service) NotificationDeliveries() []notify.Delivery {
+	ds := s.outbox.Deliveries()
+	for i := range ds {
+		ds[i].Notification.Photo = nil
+	}
+	return ds
+}
+
+// testNotifiers sends test notification to the named sink or to all sinks if
+// name is empty ignoring routing rules.
+func testNotifiers(configDirPath string, sinkName string) error {
+	var s service
+
+	err := s.loadConfig(configDirPath)
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+
+	r, err := notify.New(s.config.notifierSinksConfigs())
+	if err != nil {
+		return fmt.Errorf("create notifier: %w", err)
+	}
+
+	sinks := r.Sinks()
+
+	if sinkName != "" {
+		sink, exists := r.Sink(sinkName)
+		if !exists {
+			return fmt.Errorf("sink %s not found", sinkName)
+		}
+		sinks = []*notify.Sink{sink}
+	}
+
+	if len(sinks) == 0 {
+		return fmt.Errorf("no sinks configured")
+	}
+
+	hostName, _ := os.Hostname()
+
+	var failed int
+
+	for _, sink := range sinks {
+		ctx, cancel := context.WithTimeout(context.Background(), defaultNotificationSendTimeout)
+		err := sink.Notify(ctx, notify.Notification{
+			Type:     notify.TypeTest,
+			HostName: hostName,
+			Message:  fmt.Sprintf("[получатель=%s] тестовое оповещение Надзирателя", sink.Name),
+			Time:     time.Now(),
+		})
+		cancel()
+
+		if err != nil {
+			failed++
+			fmt.Printf("%s (%s): FAILED: %v\n", sink.Name, sink.Type, err)
+			continue
+		}
+
+		fmt.Printf("%s (%s): OK\n", sink.Name, sink.Type)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d sinks failed", failed, len(sinks))
+	}
+
+	return nil
+}
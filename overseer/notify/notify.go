@@ -0,0 +1,225 @@
+// Package notify delivers overseer notifications to configured sinks:
+// Telegram, SMTP email, generic JSON webhook and Slack or Mattermost
+// compatible incoming webhook. Every sink has routing rules which select
+// notifications by type and host group.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// TypeTest is a type of the notification sent by sink test.
+const TypeTest = "test"
+
+// States of notifications about ongoing conditions. Notifications about
+// events have no state.
+const (
+	StateFiring    = "firing"
+	StateReminder  = "reminder"
+	StateRecovered = "recovered"
+)
+
+type Notification struct {
+	// Type is an incident type or an overseer event type.
+	Type      string `json:"type"`
+	State     string `json:"state,omitempty"`
+	HostName  string `json:"host_name,omitempty"`
+	HostGroup string `json:"host_group,omitempty"`
+	UserName  string `json:"user_name,omitempty"`
+	// Condition is a stable identifier of the notified condition within type,
+	// host and user. Unlike message it doesn't contain scores or durations.
+	Condition string    `json:"condition,omitempty"`
+	Message   string    `json:"message"`
+	Time      time.Time `json:"time"`
+
+	// Photo is an optional JPEG encoded camera frame. It is sent by sinks
+	// which support attachments.
+	Photo []byte `json:"photo,omitempty"`
+}
+
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// MultiNotifier is a notifier sending notifications to several recipients.
+// Outbox delivers notification to every recipient separately, so recipient
+// failure doesn't cause resending to others, and limits rate per recipient.
+type MultiNotifier interface {
+	Notifier
+	Recipients() []string
+	NotifyRecipient(ctx context.Context, recipient string, n Notification) error
+}
+
+// Factory creates notifier. Decode unmarshals sink specific parameters from
+// the sink config into the given value.
+type Factory func(decode func(interface{}) error) (Notifier, error)
+
+var (
+	factories   = map[string]Factory{}
+	factoriesMx sync.RWMutex
+)
+
+func Register(typ string, f Factory) {
+	factoriesMx.Lock()
+	defer factoriesMx.Unlock()
+
+	if _, exists := factories[typ]; exists {
+		panic(fmt.Sprintf("notifier %s already registered", typ))
+	}
+
+	factories[typ] = f
+}
+
+func Types() []string {
+	factoriesMx.RLock()
+	defer factoriesMx.RUnlock()
+
+	var types []string
+
+	for typ := range factories {
+		types = append(types, typ)
+	}
+
+	sort.Strings(types)
+
+	return types
+}
+
+// Route selects notifications by type and host group. Empty list matches
+// any value.
+type Route struct {
+	Types      []string `yaml:"types"`
+	HostGroups []string `yaml:"host_groups"`
+}
+
+func contains(vs []string, v string) bool {
+	if len(vs) == 0 {
+		return true
+	}
+	for _, vv := range vs {
+		if vv == v {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Route) Matches(n Notification) bool {
+	return contains(r.Types, n.Type) && contains(r.HostGroups, n.HostGroup)
+}
+
+// SinkConfig configures sink. RateLimit overrides outbox rate limit of the
+// sink.
+type SinkConfig struct {
+	Name      string                 `yaml:"name"`
+	Type      string                 `yaml:"type"`
+	Routes    []Route                `yaml:"routes"`
+	RateLimit int                    `yaml:"rate_limit"`
+	Params    map[string]interface{} `yaml:",inline"`
+}
+
+type Sink struct {
+	Name      string
+	Type      string
+	Routes    []Route
+	RateLimit int
+	Notifier
+}
+
+// Matches reports whether notification should be delivered to the sink. Sink
+// without routes receives all notifications.
+func (s *Sink) Matches(n Notification) bool {
+	if len(s.Routes) == 0 {
+		return true
+	}
+	for _, r := range s.Routes {
+		if r.Matches(n) {
+			return true
+		}
+	}
+	return false
+}
+
+func NewSink(c SinkConfig) (*Sink, error) {
+	factoriesMx.RLock()
+	f, exists := factories[c.Type]
+	factoriesMx.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("unknown notifier type %s", c.Type)
+	}
+
+	n, err := f(func(v interface{}) error {
+		params, err := yaml.Marshal(c.Params)
+		if err != nil {
+			return fmt.Errorf("YAML encode params: %w", err)
+		}
+		return yaml.Unmarshal(params, v)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create %s notifier: %w", c.Type, err)
+	}
+
+	name := c.Name
+	if name == "" {
+		name = c.Type
+	}
+
+	return &Sink{Name: name, Type: c.Type, Routes: c.Routes, RateLimit: c.RateLimit, Notifier: n}, nil
+}
+
+// Router routes notifications to sinks.
+type Router struct {
+	sinks []*Sink
+}
+
+func New(cs []SinkConfig) (*Router, error) {
+	r := &Router{}
+	names := map[string]bool{}
+
+	for i, c := range cs {
+		s, err := NewSink(c)
+		if err != nil {
+			return nil, fmt.Errorf("sink #%d: %w", i, err)
+		}
+
+		if names[s.Name] {
+			return nil, fmt.Errorf("duplicate sink name %s", s.Name)
+		}
+		names[s.Name] = true
+
+		r.sinks = append(r.sinks, s)
+	}
+
+	return r, nil
+}
+
+func (r *Router) Sinks() []*Sink {
+	return r.sinks
+}
+
+func (r *Router) Sink(name string) (*Sink, bool) {
+	for _, s := range r.sinks {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+// Route returns sinks the notification should be delivered to.
+func (r *Router) Route(n Notification) []*Sink {
+	var ss []*Sink
+	for _, s := range r.sinks {
+		if s.Matches(n) {
+			ss = append(ss, s)
+		}
+	}
+	return ss
+}
@@ -0,0 +1,681 @@
+package notify
+
+import (
+	"container/heap"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultOutboxMaxAttempts    = 10
+	defaultOutboxInitialBackoff = "10s"
+	defaultOutboxMaxBackoff     = "10m"
+	defaultOutboxSendTimeout    = "30s"
+	defaultOutboxRetention      = "72h"
+	defaultOutboxRateLimit      = 20
+
+	deliveryFileExt = ".json"
+	photoFileExt    = ".jpg"
+)
+
+// OutboxConfig configures durable delivery of notifications. RateLimit is
+// a maximal number of notifications per minute sent to a sink or to every
+// recipient of a sink with several recipients, sink config can override it.
+type OutboxConfig struct {
+	DirectoryPath  string `yaml:"directory_path"`
+	MaxAttempts    int    `yaml:"max_attempts"`
+	InitialBackoff string `yaml:"initial_backoff"`
+	MaxBackoff     string `yaml:"max_backoff"`
+	SendTimeout    string `yaml:"send_timeout"`
+	Retention      string `yaml:"retention"`
+	RateLimit      int    `yaml:"rate_limit"`
+}
+
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliveryDelivered DeliveryStatus = "delivered"
+	DeliveryFailed    DeliveryStatus = "failed"
+)
+
+// Delivery is a notification delivery to a sink. Notification to a sink
+// with several recipients is delivered to every recipient separately.
+// Notification photo is stored once for all deliveries of the notification
+// and is referenced by photo ID.
+type Delivery struct {
+	ID            string         `json:"id"`
+	Sink          string         `json:"sink"`
+	Recipient     string         `json:"recipient,omitempty"`
+	Notification  Notification   `json:"notification"`
+	PhotoID       string         `json:"photo_id,omitempty"`
+	Status        DeliveryStatus `json:"status"`
+	Attempts      int            `json:"attempts"`
+	NextAttemptAt time.Time      `json:"next_attempt_at,omitempty"`
+	LastError     string         `json:"last_error,omitempty"`
+	CreatedAt     time.Time      `json:"created_at"`
+	UpdatedAt     time.Time      `json:"updated_at"`
+}
+
+// limiter is a token bucket allowing rate notifications per minute.
+type limiter struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(perMinute int) *limiter {
+	return &limiter{rate: float64(perMinute), tokens: float64(perMinute), last: time.Now()}
+}
+
+func (l *limiter) refill(now time.Time) {
+	l.tokens += now.Sub(l.last).Minutes() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+}
+
+// readyAt returns time when token will be available.
+func (l *limiter) readyAt() time.Time {
+	now := time.Now()
+
+	l.refill(now)
+
+	if l.tokens >= 1 {
+		return now
+	}
+
+	return now.Add(time.Duration((1 - l.tokens) / l.rate * float64(time.Minute)))
+}
+
+// take takes a token, it must be available.
+func (l *limiter) take() {
+	l.refill(time.Now())
+	l.tokens--
+}
+
+// dueHeap is a min heap of pending deliveries ordered by due time.
+type dueHeap []*Delivery
+
+func (h dueHeap) Len() int           { return len(h) }
+func (h dueHeap) Less(i, j int) bool { return h[i].due().Before(h[j].due()) }
+func (h dueHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *dueHeap) Push(x interface{}) {
+	*h = append(*h, x.(*Delivery))
+}
+
+func (h *dueHeap) Pop() interface{} {
+	old := *h
+	d := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return d
+}
+
+// sinkQueue delivers pending deliveries of a sink one by one. Every
+// recipient has its own limiter and pending deliveries ordered by due time,
+// deliveries without recipient share them.
+type sinkQueue struct {
+	sink      *Sink
+	rateLimit int
+	limiters  map[string]*limiter
+	pending   map[string]*dueHeap
+	wake      chan struct{}
+}
+
+func (q *sinkQueue) push(d *Delivery) {
+	h, exists := q.pending[d.Recipient]
+	if !exists {
+		h = &dueHeap{}
+		q.pending[d.Recipient] = h
+	}
+	heap.Push(h, d)
+}
+
+func (q *sinkQueue) limiter(recipient string) *limiter {
+	l, exists := q.limiters[recipient]
+	if !exists {
+		l = newLimiter(q.rateLimit)
+		q.limiters[recipient] = l
+	}
+	return l
+}
+
+// Outbox persists deliveries of notifications to sinks in directory, one
+// file per delivery, and sends them in background with retries. Every sink
+// has its own queue, so slow or failing sink doesn't delay others.
+// Undelivered notifications are sent after restart.
+type Outbox struct {
+	dirPath        string
+	router         *Router
+	onError        func(d Delivery, err error)
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	sendTimeout    time.Duration
+	retention      time.Duration
+
+	// finished are delivered and failed deliveries ordered by update time,
+	// photoRefs are numbers of pending deliveries of photos.
+	deliveries   map[string]*Delivery
+	finished     []*Delivery
+	photoRefs    map[string]int
+	deliveriesMx sync.Mutex
+
+	queues map[string]*sinkQueue
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func parseDurationDefault(name, value, def string) (time.Duration, error) {
+	if value == "" {
+		value = def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("parse %s: %w", name, err)
+	}
+	return d, nil
+}
+
+// OpenOutbox loads deliveries from directory and starts sending pending
+// ones. Failed delivery attempts are reported to onError.
+func OpenOutbox(c OutboxConfig, r *Router, onError func(d Delivery, err error)) (*Outbox, error) {
+	if c.DirectoryPath == "" {
+		return nil, fmt.Errorf("directory_path is not set")
+	}
+
+	if c.MaxAttempts == 0 {
+		c.MaxAttempts = defaultOutboxMaxAttempts
+	}
+
+	if c.RateLimit == 0 {
+		c.RateLimit = defaultOutboxRateLimit
+	}
+
+	o := &Outbox{
+		dirPath:     c.DirectoryPath,
+		router:      r,
+		onError:     onError,
+		maxAttempts: c.MaxAttempts,
+		deliveries:  map[string]*Delivery{},
+		photoRefs:   map[string]int{},
+		queues:      map[string]*sinkQueue{},
+	}
+
+	var err error
+
+	o.initialBackoff, err = parseDurationDefault("initial_backoff", c.InitialBackoff, defaultOutboxInitialBackoff)
+	if err != nil {
+		return nil, err
+	}
+
+	o.maxBackoff, err = parseDurationDefault("max_backoff", c.MaxBackoff, defaultOutboxMaxBackoff)
+	if err != nil {
+		return nil, err
+	}
+
+	o.sendTimeout, err = parseDurationDefault("send_timeout", c.SendTimeout, defaultOutboxSendTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	o.retention, err = parseDurationDefault("retention", c.Retention, defaultOutboxRetention)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(o.dirPath, 0775)
+	if err != nil {
+		return nil, fmt.Errorf("create directory: %w", err)
+	}
+
+	for _, s := range r.Sinks() {
+		rateLimit := s.RateLimit
+		if rateLimit == 0 {
+			rateLimit = c.RateLimit
+		}
+		o.queues[s.Name] = &sinkQueue{
+			sink:      s,
+			rateLimit: rateLimit,
+			limiters:  map[string]*limiter{},
+			pending:   map[string]*dueHeap{},
+			wake:      make(chan struct{}, 1),
+		}
+	}
+
+	err = o.load()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	o.cancel = cancel
+
+	for _, q := range o.queues {
+		o.wg.Add(1)
+		go func(q *sinkQueue) {
+			defer o.wg.Done()
+			o.run(ctx, q)
+		}(q)
+	}
+
+	return o, nil
+}
+
+func (o *Outbox) filePath(id string) string {
+	return filepath.Join(o.dirPath, id+deliveryFileExt)
+}
+
+func (o *Outbox) photoFilePath(id string) string {
+	return filepath.Join(o.dirPath, id+photoFileExt)
+}
+
+// load loads deliveries and queues pending ones. Deliveries to sinks which
+// are not configured anymore are failed. Photos without pending deliveries
+// are removed.
+func (o *Outbox) load() error {
+	fis, err := ioutil.ReadDir(o.dirPath)
+	if err != nil {
+		return fmt.Errorf("read directory: %w", err)
+	}
+
+	photos := map[string][]byte{}
+
+	for _, fi := range fis {
+		if fi.IsDir() || filepath.Ext(fi.Name()) != deliveryFileExt {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(o.dirPath, fi.Name()))
+		if err != nil {
+			return fmt.Errorf("read delivery file: %w", err)
+		}
+
+		var d Delivery
+
+		err = json.Unmarshal(data, &d)
+		if err != nil {
+			return fmt.Errorf("JSON decode delivery file %s: %w", fi.Name(), err)
+		}
+
+		if d.ID != strings.TrimSuffix(fi.Name(), deliveryFileExt) {
+			return fmt.Errorf("delivery file %s has ID %s", fi.Name(), d.ID)
+		}
+
+		o.deliveries[d.ID] = &d
+
+		if d.Status != DeliveryPending {
+			o.finished = append(o.finished, &d)
+			continue
+		}
+
+		q, exists := o.queues[d.Sink]
+		if !exists {
+			d.Status = DeliveryFailed
+			d.LastError = "sink is not configured"
+			o.save(&d)
+			o.finished = append(o.finished, &d)
+			continue
+		}
+
+		if d.PhotoID != "" {
+			photo, loaded := photos[d.PhotoID]
+			if !loaded {
+				// Notification is sent without photo if it is lost.
+				photo, _ = ioutil.ReadFile(o.photoFilePath(d.PhotoID))
+				photos[d.PhotoID] = photo
+			}
+			d.Notification.Photo = photo
+			o.photoRefs[d.PhotoID]++
+		}
+
+		q.push(&d)
+	}
+
+	sort.Slice(o.finished, func(i, j int) bool {
+		return o.finished[i].UpdatedAt.Before(o.finished[j].UpdatedAt)
+	})
+
+	for _, fi := range fis {
+		id := strings.TrimSuffix(fi.Name(), photoFileExt)
+		if !fi.IsDir() && filepath.Ext(fi.Name()) == photoFileExt && o.photoRefs[id] == 0 {
+			os.Remove(o.photoFilePath(id))
+		}
+	}
+
+	return nil
+}
+
+// finish moves delivery which isn't pending anymore to finished ones and
+// removes its photo if no other pending delivery needs it.
+func (o *Outbox) finish(d *Delivery) {
+	o.finished = append(o.finished, d)
+
+	if d.PhotoID == "" {
+		return
+	}
+
+	d.Notification.Photo = nil
+
+	o.photoRefs[d.PhotoID]--
+	if o.photoRefs[d.PhotoID] <= 0 {
+		delete(o.photoRefs, d.PhotoID)
+		os.Remove(o.photoFilePath(d.PhotoID))
+	}
+}
+
+// removeExpired removes finished deliveries older than retention.
+func (o *Outbox) removeExpired() {
+	for len(o.finished) > 0 && time.Since(o.finished[0].UpdatedAt) >= o.retention {
+		d := o.finished[0]
+		o.finished[0] = nil
+		o.finished = o.finished[1:]
+
+		os.Remove(o.filePath(d.ID))
+		delete(o.deliveries, d.ID)
+	}
+}
+
+// save writes delivery file, photo is stored in its own file.
+func (o *Outbox) save(d *Delivery) error {
+	d.UpdatedAt = time.Now()
+
+	fd := *d
+	fd.Notification.Photo = nil
+
+	data, err := json.MarshalIndent(fd, "", "  ")
+	if err != nil {
+		return fmt.Errorf("JSON encode delivery: %w", err)
+	}
+
+	tmpFilePath := o.filePath(d.ID) + ".tmp"
+
+	err = ioutil.WriteFile(tmpFilePath, data, 0664)
+	if err != nil {
+		return fmt.Errorf("write delivery file: %w", err)
+	}
+
+	err = os.Rename(tmpFilePath, o.filePath(d.ID))
+	if err != nil {
+		return fmt.Errorf("rename delivery file: %w", err)
+	}
+
+	return nil
+}
+
+func newID() (string, error) {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return time.Now().Format("20060102150405") + "-" + hex.EncodeToString(b), nil
+}
+
+// Enqueue persists deliveries of notification to sinks it is routed to.
+// Notification photo is written once for all its deliveries.
+func (o *Outbox) Enqueue(n Notification) error {
+	sinks := o.router.Route(n)
+	if len(sinks) == 0 {
+		return nil
+	}
+
+	var photoID string
+
+	if len(n.Photo) > 0 {
+		var err error
+
+		photoID, err = newID()
+		if err != nil {
+			return fmt.Errorf("generate photo ID: %w", err)
+		}
+
+		err = ioutil.WriteFile(o.photoFilePath(photoID), n.Photo, 0664)
+		if err != nil {
+			return fmt.Errorf("write photo file: %w", err)
+		}
+	}
+
+	for _, s := range sinks {
+		recipients := []string{""}
+		if m, ok := s.Notifier.(MultiNotifier); ok {
+			recipients = m.Recipients()
+		}
+
+		for _, r := range recipients {
+			id, err := newID()
+			if err != nil {
+				return fmt.Errorf("generate ID: %w", err)
+			}
+
+			d := &Delivery{
+				ID:           id,
+				Sink:         s.Name,
+				Recipient:    r,
+				Notification: n,
+				PhotoID:      photoID,
+				Status:       DeliveryPending,
+				CreatedAt:    time.Now(),
+			}
+
+			o.deliveriesMx.Lock()
+			err = o.save(d)
+			if err == nil {
+				o.deliveries[id] = d
+				if photoID != "" {
+					o.photoRefs[photoID]++
+				}
+				o.queues[s.Name].push(d)
+			}
+			o.deliveriesMx.Unlock()
+
+			if err != nil {
+				return fmt.Errorf("save delivery to %s: %w", s.Name, err)
+			}
+		}
+
+		select {
+		case o.queues[s.Name].wake <- struct{}{}:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// Deliveries returns copies of deliveries, newest first.
+func (o *Outbox) Deliveries() []Delivery {
+	o.deliveriesMx.Lock()
+	defer o.deliveriesMx.Unlock()
+
+	ds := make([]Delivery, 0, len(o.deliveries))
+	for _, d := range o.deliveries {
+		ds = append(ds, *d)
+	}
+
+	sort.Slice(ds, func(i, j int) bool {
+		return ds[i].CreatedAt.After(ds[j].CreatedAt)
+	})
+
+	return ds
+}
+
+// due returns time of the next delivery attempt.
+func (d *Delivery) due() time.Time {
+	if d.NextAttemptAt.IsZero() {
+		return d.CreatedAt
+	}
+	return d.NextAttemptAt
+}
+
+// next takes pending delivery of the queue sink if it is ready to be sent.
+// Otherwise it returns time when the first delivery will be ready, zero if
+// there are no pending deliveries. Delivery waiting for retry or for rate
+// limited recipient doesn't delay other recipients.
+func (o *Outbox) next(q *sinkQueue) (*Delivery, time.Time) {
+	o.deliveriesMx.Lock()
+	defer o.deliveriesMx.Unlock()
+
+	o.removeExpired()
+
+	var (
+		next   *dueHeap
+		nextAt time.Time
+	)
+
+	for r, h := range q.pending {
+		if h.Len() == 0 {
+			continue
+		}
+
+		at := (*h)[0].due()
+		if l := q.limiter(r).readyAt(); l.After(at) {
+			at = l
+		}
+
+		if next == nil || at.Before(nextAt) {
+			next, nextAt = h, at
+		}
+	}
+
+	if next == nil || time.Now().Before(nextAt) {
+		return nil, nextAt
+	}
+
+	return heap.Pop(next).(*Delivery), nextAt
+}
+
+func (o *Outbox) backoff(attempts int) time.Duration {
+	b := o.initialBackoff
+	for i := 1; i < attempts && b < o.maxBackoff; i++ {
+		b *= 2
+	}
+	if b > o.maxBackoff {
+		b = o.maxBackoff
+	}
+	return b
+}
+
+// sleep waits for duration, wake up or context done. It returns false on
+// context done.
+func sleep(ctx context.Context, d time.Duration, wake <-chan struct{}) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+	case <-wake:
+	case <-ctx.Done():
+		return false
+	}
+
+	return true
+}
+
+func (o *Outbox) run(ctx context.Context, q *sinkQueue) {
+	const idle = time.Minute
+
+	for {
+		d, at := o.next(q)
+		if d == nil {
+			wait := idle
+			if !at.IsZero() {
+				wait = time.Until(at)
+			}
+			if !sleep(ctx, wait, q.wake) {
+				return
+			}
+			continue
+		}
+
+		o.deliveriesMx.Lock()
+		n, recipient := d.Notification, d.Recipient
+		o.deliveriesMx.Unlock()
+
+		q.limiter(recipient).take()
+
+		sendCtx, cancel := context.WithTimeout(ctx, o.sendTimeout)
+		err := o.send(sendCtx, q.sink, recipient, n)
+		cancel()
+
+		if ctx.Err() != nil {
+			// Delivery is interrupted by close and will be retried after
+			// restart.
+			return
+		}
+
+		o.deliveriesMx.Lock()
+
+		d.Attempts++
+
+		switch {
+		case err == nil:
+			d.Status = DeliveryDelivered
+			d.LastError = ""
+			d.NextAttemptAt = time.Time{}
+		case d.Attempts >= o.maxAttempts:
+			d.Status = DeliveryFailed
+			d.LastError = err.Error()
+			d.NextAttemptAt = time.Time{}
+		default:
+			d.LastError = err.Error()
+			d.NextAttemptAt = time.Now().Add(o.backoff(d.Attempts))
+		}
+
+		saveErr := o.save(d)
+		failed := *d
+
+		if d.Status == DeliveryPending {
+			q.push(d)
+		} else {
+			o.finish(d)
+		}
+
+		o.deliveriesMx.Unlock()
+
+		if err == nil {
+			err = saveErr
+		}
+
+		if err != nil && o.onError != nil {
+			o.onError(failed, err)
+		}
+	}
+}
+
+// send sends notification to the sink recipient or to the whole sink if
+// recipient isn't set.
+func (o *Outbox) send(ctx context.Context, s *Sink, recipient string, n Notification) error {
+	if recipient == "" {
+		return s.Notify(ctx, n)
+	}
+
+	m, ok := s.Notifier.(MultiNotifier)
+	if !ok {
+		return fmt.Errorf("sink doesn't support recipients")
+	}
+
+	return m.NotifyRecipient(ctx, recipient, n)
+}
+
+// Close stops sending and waits for sends in progress to be interrupted.
+// Pending deliveries stay in directory.
+func (o *Outbox) Close() error {
+	o.cancel()
+	o.wg.Wait()
+	return nil
+}
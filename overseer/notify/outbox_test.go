@@ -0,0 +1,374 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeNotifier fails first failures notifications and records the others by
+// recipient.
+type fakeNotifier struct {
+	mx         sync.Mutex
+	failures   int
+	recipients []string
+	sent       map[string][]Notification
+}
+
+func newFakeNotifier(failures int, recipients ...string) *fakeNotifier {
+	return &fakeNotifier{failures: failures, recipients: recipients, sent: map[string][]Notification{}}
+}
+
+func (f *fakeNotifier) Notify(ctx context.Context, n Notification) error {
+	return f.NotifyRecipient(ctx, "", n)
+}
+
+func (f *fakeNotifier) NotifyRecipient(ctx context.Context, recipient string, n Notification) error {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	if f.failures > 0 {
+		f.failures--
+		return errors.New("sink is unavailable")
+	}
+
+	f.sent[recipient] = append(f.sent[recipient], n)
+
+	return nil
+}
+
+func (f *fakeNotifier) sentCount(recipient string) int {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	return len(f.sent[recipient])
+}
+
+// multiNotifier is a fake notifier with several recipients.
+type multiNotifier struct {
+	*fakeNotifier
+}
+
+func (m multiNotifier) Recipients() []string {
+	return m.recipients
+}
+
+func testRouter(name string, n Notifier) *Router {
+	return &Router{sinks: []*Sink{{Name: name, Type: "fake", Notifier: n}}}
+}
+
+func tempDir(t *testing.T) string {
+	dirPath, err := ioutil.TempDir("", "outbox-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dirPath) })
+	return dirPath
+}
+
+// waitDeliveries waits until every delivery is not pending.
+func waitDeliveries(t *testing.T, o *Outbox) []Delivery {
+	deadline := time.Now().Add(5 * time.Second)
+
+	for {
+		ds := o.Deliveries()
+
+		done := true
+		for _, d := range ds {
+			if d.Status == DeliveryPending {
+				done = false
+			}
+		}
+
+		if done {
+			return ds
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("deliveries are pending: %+v", ds)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestOutbox(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int
+		recipients   []string
+		maxAttempts  int
+		wantStatus   DeliveryStatus
+		wantAttempts int
+		wantErrors   int
+	}{
+		{
+			name:         "delivered",
+			wantStatus:   DeliveryDelivered,
+			wantAttempts: 1,
+		},
+		{
+			name:         "retried until delivered",
+			failures:     2,
+			wantStatus:   DeliveryDelivered,
+			wantAttempts: 3,
+			wantErrors:   2,
+		},
+		{
+			name:         "failed after max attempts",
+			failures:     10,
+			maxAttempts:  3,
+			wantStatus:   DeliveryFailed,
+			wantAttempts: 3,
+			wantErrors:   3,
+		},
+		{
+			name:         "delivered to every recipient",
+			recipients:   []string{"alice", "bob"},
+			wantStatus:   DeliveryDelivered,
+			wantAttempts: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeNotifier(tt.failures, tt.recipients...)
+
+			var n Notifier = f
+			if len(tt.recipients) > 0 {
+				n = multiNotifier{f}
+			}
+
+			var (
+				errorsMx sync.Mutex
+				errs     int
+			)
+
+			o, err := OpenOutbox(OutboxConfig{
+				DirectoryPath:  tempDir(t),
+				MaxAttempts:    tt.maxAttempts,
+				InitialBackoff: "10ms",
+				MaxBackoff:     "20ms",
+			}, testRouter("fake", n), func(d Delivery, err error) {
+				errorsMx.Lock()
+				errs++
+				errorsMx.Unlock()
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer o.Close()
+
+			err = o.Enqueue(Notification{Type: "unknown_person", Message: "test", Time: time.Now()})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ds := waitDeliveries(t, o)
+
+			recipients := tt.recipients
+			if len(recipients) == 0 {
+				recipients = []string{""}
+			}
+
+			if len(ds) != len(recipients) {
+				t.Fatalf("deliveries = %d, want %d", len(ds), len(recipients))
+			}
+
+			for _, d := range ds {
+				if d.Status != tt.wantStatus || d.Attempts != tt.wantAttempts {
+					t.Errorf("delivery to %q status = %s, attempts = %d, want %s, %d",
+						d.Recipient, d.Status, d.Attempts, tt.wantStatus, tt.wantAttempts)
+				}
+			}
+
+			if tt.wantStatus == DeliveryDelivered {
+				for _, r := range recipients {
+					if got := f.sentCount(r); got != 1 {
+						t.Errorf("sent to %q = %d, want 1", r, got)
+					}
+				}
+			}
+
+			errorsMx.Lock()
+			defer errorsMx.Unlock()
+
+			if errs != tt.wantErrors {
+				t.Errorf("errors = %d, want %d", errs, tt.wantErrors)
+			}
+		})
+	}
+}
+
+func TestOutboxPersistence(t *testing.T) {
+	tests := []struct {
+		name string
+		// sink is a name of the sink configured after reopen.
+		sink          string
+		wantStatus    DeliveryStatus
+		wantAttempts  int
+		wantLastError string
+	}{
+		{
+			name:         "pending delivery sent after reopen",
+			sink:         "fake",
+			wantStatus:   DeliveryDelivered,
+			wantAttempts: 2,
+		},
+		{
+			name:          "sink removed",
+			sink:          "other",
+			wantStatus:    DeliveryFailed,
+			wantAttempts:  1,
+			wantLastError: "sink is not configured",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := OutboxConfig{
+				DirectoryPath:  tempDir(t),
+				InitialBackoff: "100ms",
+			}
+
+			attempted := make(chan struct{}, 1)
+
+			o, err := OpenOutbox(c, testRouter("fake", newFakeNotifier(1)), func(d Delivery, err error) {
+				attempted <- struct{}{}
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = o.Enqueue(Notification{Type: "unknown_person", Message: "test", Time: time.Now()})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			<-attempted
+			o.Close()
+
+			f := newFakeNotifier(0)
+
+			o, err = OpenOutbox(c, testRouter(tt.sink, f), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer o.Close()
+
+			ds := waitDeliveries(t, o)
+			if len(ds) != 1 {
+				t.Fatalf("deliveries = %d, want 1", len(ds))
+			}
+
+			d := ds[0]
+
+			if d.Status != tt.wantStatus || d.Attempts != tt.wantAttempts || d.LastError != tt.wantLastError {
+				t.Errorf("delivery status = %s, attempts = %d, last error = %q, want %s, %d, %q",
+					d.Status, d.Attempts, d.LastError, tt.wantStatus, tt.wantAttempts, tt.wantLastError)
+			}
+
+			if d.Notification.Message != "test" {
+				t.Errorf("notification message = %q, want %q", d.Notification.Message, "test")
+			}
+		})
+	}
+}
+
+// photoFiles returns paths of photo files in the outbox directory.
+func photoFiles(t *testing.T, dirPath string) []string {
+	paths, err := filepath.Glob(filepath.Join(dirPath, "*"+photoFileExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return paths
+}
+
+func TestOutboxPhoto(t *testing.T) {
+	c := OutboxConfig{
+		DirectoryPath:  tempDir(t),
+		InitialBackoff: "100ms",
+	}
+
+	recipients := []string{"alice", "bob", "carol"}
+	photo := []byte("photo")
+
+	attempted := make(chan struct{}, 1)
+
+	f1 := newFakeNotifier(1, recipients...)
+
+	o, err := OpenOutbox(c, testRouter("fake", multiNotifier{f1}), func(d Delivery, err error) {
+		attempted <- struct{}{}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = o.Enqueue(Notification{Type: "unknown_person", Message: "test", Time: time.Now(), Photo: photo})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	<-attempted
+	o.Close()
+
+	if got := len(photoFiles(t, c.DirectoryPath)); got != 1 {
+		t.Fatalf("photo files = %d, want 1", got)
+	}
+
+	paths, err := filepath.Glob(filepath.Join(c.DirectoryPath, "*"+deliveryFileExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range paths {
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var d Delivery
+
+		err = json.Unmarshal(data, &d)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if d.PhotoID == "" || len(d.Notification.Photo) > 0 {
+			t.Errorf("delivery file to %q has photo ID = %q, photo = %d bytes, want photo ID only",
+				d.Recipient, d.PhotoID, len(d.Notification.Photo))
+		}
+	}
+
+	f2 := newFakeNotifier(0, recipients...)
+
+	o, err = OpenOutbox(c, testRouter("fake", multiNotifier{f2}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o.Close()
+
+	waitDeliveries(t, o)
+
+	for _, r := range recipients {
+		ns := append(f1.sent[r], f2.sent[r]...)
+		if len(ns) == 0 {
+			t.Errorf("nothing is sent to %q", r)
+		}
+		for _, n := range ns {
+			if !bytes.Equal(n.Photo, photo) {
+				t.Errorf("photo sent to %q = %q, want %q", r, n.Photo, photo)
+			}
+		}
+	}
+
+	if got := len(photoFiles(t, c.DirectoryPath)); got != 0 {
+		t.Errorf("photo files after delivery = %d, want 0", got)
+	}
+}
@@ -0,0 +1,56 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+type SlackConfig struct {
+	URL string `yaml:"url"`
+
+	// Channel, Username and IconEmoji override incoming webhook defaults.
+	Channel   string `yaml:"channel"`
+	Username  string `yaml:"username"`
+	IconEmoji string `yaml:"icon_emoji"`
+}
+
+// Slack posts message to Slack or Mattermost incoming webhook.
+type Slack struct {
+	config SlackConfig
+	client *http.Client
+}
+
+func NewSlack(c SlackConfig) (*Slack, error) {
+	if c.URL == "" {
+		return nil, fmt.Errorf("url is not set")
+	}
+	return &Slack{config: c, client: &http.Client{}}, nil
+}
+
+func init() {
+	Register("slack", func(decode func(interface{}) error) (Notifier, error) {
+		var c SlackConfig
+		err := decode(&c)
+		if err != nil {
+			return nil, fmt.Errorf("decode config: %w", err)
+		}
+		return NewSlack(c)
+	})
+}
+
+type slackMessage struct {
+	Text      string `json:"text"`
+	Channel   string `json:"channel,omitempty"`
+	Username  string `json:"username,omitempty"`
+	IconEmoji string `json:"icon_emoji,omitempty"`
+}
+
+func (s *Slack) Notify(ctx context.Context, n Notification) error {
+	return postJSON(ctx, s.client, s.config.URL, nil, slackMessage{
+		Text:      n.Message,
+		Channel:   s.config.Channel,
+		Username:  s.config.Username,
+		IconEmoji: s.config.IconEmoji,
+	})
+}
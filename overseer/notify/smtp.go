@@ -0,0 +1,156 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+const (
+	defaultSMTPSubjectPrefix = "[Oko]"
+
+	// smtpBoundary separates parts of message with attachment. Base64
+	// encoded parts can't contain it.
+	smtpBoundary = "oko-notification-boundary"
+)
+
+type SMTPConfig struct {
+	// Address is a host:port of the SMTP server. STARTTLS is used if server
+	// supports it.
+	Address  string `yaml:"address"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+
+	From          string   `yaml:"from"`
+	To            []string `yaml:"to"`
+	SubjectPrefix string   `yaml:"subject_prefix"`
+}
+
+type SMTP struct {
+	config SMTPConfig
+	auth   smtp.Auth
+}
+
+func NewSMTP(c SMTPConfig) (*SMTP, error) {
+	if c.Address == "" {
+		return nil, fmt.Errorf("address is not set")
+	}
+
+	host, _, err := net.SplitHostPort(c.Address)
+	if err != nil {
+		return nil, fmt.Errorf("parse address: %w", err)
+	}
+
+	if c.From == "" {
+		return nil, fmt.Errorf("from is not set")
+	}
+
+	if len(c.To) == 0 {
+		return nil, fmt.Errorf("to is not set")
+	}
+
+	if c.SubjectPrefix == "" {
+		c.SubjectPrefix = defaultSMTPSubjectPrefix
+	}
+
+	s := &SMTP{config: c}
+
+	if c.Username != "" {
+		s.auth = smtp.PlainAuth("", c.Username, c.Password, host)
+	}
+
+	return s, nil
+}
+
+func init() {
+	Register("smtp", func(decode func(interface{}) error) (Notifier, error) {
+		var c SMTPConfig
+		err := decode(&c)
+		if err != nil {
+			return nil, fmt.Errorf("decode config: %w", err)
+		}
+		return NewSMTP(c)
+	})
+}
+
+func (s *SMTP) subject(n Notification) string {
+	subject := s.config.SubjectPrefix + " " + n.Type
+	if n.HostName != "" {
+		subject += " " + n.HostName
+	}
+	return subject
+}
+
+func (s *SMTP) message(n Notification) []byte {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "From: %s\r\n", s.config.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(s.config.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", s.subject(n)))
+	fmt.Fprintf(&b, "Date: %s\r\n", n.Time.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+
+	if len(n.Photo) == 0 {
+		b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+		b.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
+		writeBase64(&b, []byte(n.Message+"\r\n"))
+		return b.Bytes()
+	}
+
+	fmt.Fprintf(&b, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", smtpBoundary)
+
+	fmt.Fprintf(&b, "--%s\r\n", smtpBoundary)
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
+	writeBase64(&b, []byte(n.Message+"\r\n"))
+
+	fmt.Fprintf(&b, "--%s\r\n", smtpBoundary)
+	b.WriteString("Content-Type: image/jpeg\r\n")
+	b.WriteString("Content-Transfer-Encoding: base64\r\n")
+	b.WriteString("Content-Disposition: attachment; filename=\"frame.jpg\"\r\n\r\n")
+	writeBase64(&b, n.Photo)
+
+	fmt.Fprintf(&b, "--%s--\r\n", smtpBoundary)
+
+	return b.Bytes()
+}
+
+// writeBase64 writes base64 encoded data split into lines.
+func writeBase64(b *bytes.Buffer, data []byte) {
+	const lineLength = 76
+
+	s := base64.StdEncoding.EncodeToString(data)
+
+	for len(s) > lineLength {
+		b.WriteString(s[:lineLength] + "\r\n")
+		s = s[lineLength:]
+	}
+
+	b.WriteString(s + "\r\n")
+}
+
+// Notify sends email. SMTP client doesn't support context, so delivery is
+// abandoned on context done.
+func (s *SMTP) Notify(ctx context.Context, n Notification) error {
+	errs := make(chan error, 1)
+
+	go func() {
+		errs <- smtp.SendMail(s.config.Address, s.auth, s.config.From, s.config.To, s.message(n))
+	}()
+
+	select {
+	case err := <-errs:
+		if err != nil {
+			return fmt.Errorf("send mail: %w", err)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
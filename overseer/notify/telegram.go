@@ -0,0 +1,158 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	defaultTelegramTimeout = 30 * time.Second
+
+	telegramMaxCaptionLength = 1024
+)
+
+type TelegramConfig struct {
+	BotToken   string `yaml:"bot_token"`
+	Recipients []int  `yaml:"recipients"`
+}
+
+type Telegram struct {
+	config TelegramConfig
+
+	bot   *telebot.Bot
+	botMx sync.Mutex
+}
+
+func NewTelegram(c TelegramConfig) (*Telegram, error) {
+	if c.BotToken == "" {
+		return nil, fmt.Errorf("bot_token is not set")
+	}
+
+	if len(c.Recipients) == 0 {
+		return nil, fmt.Errorf("recipients are not set")
+	}
+
+	return &Telegram{config: c}, nil
+}
+
+func init() {
+	Register("telegram", func(decode func(interface{}) error) (Notifier, error) {
+		var c TelegramConfig
+		err := decode(&c)
+		if err != nil {
+			return nil, fmt.Errorf("decode config: %w", err)
+		}
+		return NewTelegram(c)
+	})
+}
+
+// Bot returns Telegram bot. Bot is created on first use since its creation
+// requires Telegram to be reachable.
+func (t *Telegram) Bot() (*telebot.Bot, error) {
+	t.botMx.Lock()
+	defer t.botMx.Unlock()
+
+	if t.bot != nil {
+		return t.bot, nil
+	}
+
+	b, err := telebot.NewBot(telebot.Settings{
+		Token:  t.config.BotToken,
+		Client: &http.Client{Timeout: defaultTelegramTimeout},
+		Poller: &telebot.LongPoller{
+			Timeout: 10 * time.Second,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create bot: %w", err)
+	}
+
+	t.bot = b
+
+	return b, nil
+}
+
+// message returns notification text or photo with notification text as
+// caption.
+func (t *Telegram) message(n Notification) interface{} {
+	if len(n.Photo) == 0 {
+		return n.Message
+	}
+
+	caption := []rune(n.Message)
+	if len(caption) > telegramMaxCaptionLength {
+		caption = append(caption[:telegramMaxCaptionLength-1], '…')
+	}
+
+	return &telebot.Photo{
+		File:    telebot.FromReader(bytes.NewReader(n.Photo)),
+		Caption: string(caption),
+	}
+}
+
+func (t *Telegram) Recipients() []string {
+	rs := make([]string, 0, len(t.config.Recipients))
+	for _, r := range t.config.Recipients {
+		rs = append(rs, strconv.Itoa(r))
+	}
+	return rs
+}
+
+// NotifyRecipient sends message to the recipient given by chat ID.
+func (t *Telegram) NotifyRecipient(ctx context.Context, recipient string, n Notification) error {
+	id, err := strconv.Atoi(recipient)
+	if err != nil {
+		return fmt.Errorf("parse recipient %s: %w", recipient, err)
+	}
+
+	b, err := t.Bot()
+	if err != nil {
+		return err
+	}
+
+	_, err = b.Send(&telebot.User{ID: id}, t.message(n))
+	if err != nil {
+		return fmt.Errorf("send to %s: %w", recipient, err)
+	}
+
+	return nil
+}
+
+// Notify sends message to all recipients. Telegram client doesn't support
+// context, so context is only checked between recipients.
+func (t *Telegram) Notify(ctx context.Context, n Notification) error {
+	b, err := t.Bot()
+	if err != nil {
+		return err
+	}
+
+	var (
+		failed  int
+		lastErr error
+	)
+
+	for _, r := range t.config.Recipients {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		_, err := b.Send(&telebot.User{ID: r}, t.message(n))
+		if err != nil {
+			failed++
+			lastErr = err
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("send to %d of %d recipients: %w", failed, len(t.config.Recipients), lastErr)
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+type WebhookConfig struct {
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+}
+
+// Webhook posts notification as JSON.
+type Webhook struct {
+	config WebhookConfig
+	client *http.Client
+}
+
+func NewWebhook(c WebhookConfig) (*Webhook, error) {
+	if c.URL == "" {
+		return nil, fmt.Errorf("url is not set")
+	}
+	return &Webhook{config: c, client: &http.Client{}}, nil
+}
+
+func init() {
+	Register("webhook", func(decode func(interface{}) error) (Notifier, error) {
+		var c WebhookConfig
+		err := decode(&c)
+		if err != nil {
+			return nil, fmt.Errorf("decode config: %w", err)
+		}
+		return NewWebhook(c)
+	})
+}
+
+// postJSON posts data as JSON and checks that response status is 2xx.
+func postJSON(ctx context.Context, client *http.Client, url string, headers map[string]string, data interface{}) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("JSON encode request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("do request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("unexpected response status %d: %s", res.StatusCode, bytes.TrimSpace(msg))
+	}
+
+	return nil
+}
+
+func (w *Webhook) Notify(ctx context.Context, n Notification) error {
+	return postJSON(ctx, w.client, w.config.URL, w.config.Headers, n)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dimuls/oko/face"
+	"github.com/dimuls/oko/overseer/notify"
+)
+
+const defaultRecognitionPause = "10m"
+
+// recognitionPause pauses recognition on all hosts when face API can't serve
+// any request, for example when quota is exceeded.
+type recognitionPause struct {
+	until    time.Time
+	category face.ErrorCategory
+	notified bool
+}
+
+// pauseRecognition pauses recognition and returns true if it wasn't paused
+// already.
+func (s *service) pauseRecognition(category face.ErrorCategory) bool {
+	s.pauseMx.Lock()
+	defer s.pauseMx.Unlock()
+
+	if time.Now().Before(s.pause.until) {
+		return false
+	}
+
+	s.pause = recognitionPause{
+		until:    time.Now().Add(s.config.RecognitionPause),
+		category: category,
+		notified: true,
+	}
+
+	return true
+}
+
+func (s *service) recognitionPaused() (face.ErrorCategory, bool) {
+	s.pauseMx.Lock()
+	defer s.pauseMx.Unlock()
+
+	return s.pause.category, time.Now().Before(s.pause.until)
+}
+
+// checkRecognitionPause notifies once when recognition pause is over.
+func (s *service) checkRecognitionPause() {
+	s.pauseMx.Lock()
+	defer s.pauseMx.Unlock()
+
+	if !s.pause.notified || time.Now().Before(s.pause.until) {
+		return
+	}
+
+	s.pause.notified = false
+
+	msg := fmt.Sprintf("[категория_ошибки=%s] распознавание возобновлено", s.pause.category)
+	eLog.Info(1, msg)
+	s.notify(notify.Notification{
+		Type:      notificationRecognitionResumed,
+		Condition: string(s.pause.category),
+		Message:   msg,
+	})
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"image"
+	"time"
+
+	"github.com/dimuls/oko/face"
+)
+
+const (
+	defaultFrameReuseMaxAge          = "1m"
+	defaultFrameReuseMaxHashDistance = 6
+	defaultFrameReuseMaxDifference   = 6
+)
+
+// frameReuseConfig configures reuse of the last verified recognition while
+// camera scene is stable.
+type frameReuseConfig struct {
+	Enabled         bool    `yaml:"enabled"`
+	MaxAge          string  `yaml:"max_age"`
+	MaxHashDistance int     `yaml:"max_hash_distance"`
+	MaxDifference   float64 `yaml:"max_difference"`
+}
+
+// verifiedResult is the last authorized recognition of the host. Face box
+// and bystanders are kept to check liveness and bystanders on reuse, local
+// faces is a number of faces found by the local detector on the frame.
+type verifiedResult struct {
+	signature        face.Signature
+	activeUser       string
+	recognizedUserID string
+	score            float64
+	facesCount       int
+	box              image.Rectangle
+	bystanders       []face.Recognition
+	localFaces       int
+	verifiedAt       time.Time
+}
+
+// reusableResult returns the last verified result if it is fresh enough, the
+// active user is the same and camera frame hasn't meaningfully changed since.
+// Frame with other number of faces found by the local detector isn't reused,
+// because someone could have entered or left the frame. Frame signature is
+// returned to remember it with the next verified result.
+func (s *service) reusableResult(st *hostState, img image.Image, activeUser string) (verifiedResult, face.Signature, bool) {
+	if img == nil {
+		st.verified = nil
+		return verifiedResult{}, face.Signature{}, false
+	}
+
+	signature := face.Sign(img)
+
+	v := st.verified
+	st.verified = nil
+
+	if v == nil || v.activeUser != activeUser ||
+		time.Since(v.verifiedAt) > s.config.FrameReuseMaxAge ||
+		signature.Distance(v.signature) > s.config.FrameReuse.MaxHashDistance ||
+		signature.Difference(v.signature) > s.config.FrameReuse.MaxDifference ||
+		len(face.Detect(img)) != v.localFaces {
+		return verifiedResult{}, signature, false
+	}
+
+	st.verified = v
+
+	return *v, signature, true
+}
@@ -1,33 +1,33 @@
-// +build windows
-
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"image"
 	"io"
 	"io/ioutil"
 	"os"
 	"path"
-	"path/filepath"
 	"sync"
 	"time"
 
-	"golang.org/x/sys/windows/svc"
-	"golang.org/x/sys/windows/svc/debug"
-	"golang.org/x/sys/windows/svc/eventlog"
-	"gopkg.in/tucnak/telebot.v2"
 	"gopkg.in/yaml.v2"
 
+	"github.com/dimuls/oko/enrollment"
 	"github.com/dimuls/oko/entity"
 	"github.com/dimuls/oko/face"
+	"github.com/dimuls/oko/overseer/notify"
 	"github.com/dimuls/oko/overseer/web"
 )
 
 const (
 	hostConfigFileName = "host.conf"
-)
 
-var eLog debug.Log
+	defaultAcceptanceThreshold = 0.8
+	defaultRejectionThreshold  = 0.5
+)
 
 type serviceConfigRaw struct {
 	HostsConfigsDirectoryPath string `yaml:"hosts_configs_directory_path"`
@@ -42,10 +42,42 @@ type serviceConfigRaw struct {
 	CheckOnlineTimeout      string `yaml:"check_online_timeout"`
 	CheckAgentOnlineTimeout string `yaml:"check_agent_online_timeout"`
 
+	AcceptanceThreshold float64 `yaml:"acceptance_threshold"`
+	RejectionThreshold  float64 `yaml:"rejection_threshold"`
+
+	BystanderAction   action   `yaml:"bystander_action"`
+	AllowedBystanders []string `yaml:"allowed_bystanders"`
+
+	Liveness livenessConfig `yaml:"liveness"`
+
+	FrameReuse frameReuseConfig `yaml:"frame_reuse"`
+
+	CameraTampering cameraTamperingConfig `yaml:"camera_tampering"`
+
+	Evidence evidenceConfig `yaml:"evidence"`
+
+	Correlation correlationConfig `yaml:"correlation"`
+
+	Alerting alertingConfig `yaml:"alerting"`
+
+	AdaptiveEnrollment adaptiveEnrollmentConfig `yaml:"adaptive_enrollment"`
+
+	HostGroups map[string]hostGroupConfig `yaml:"host_groups"`
+
+	AuditLogPath string `yaml:"audit_log_path"`
+
+	RecognitionPause string `yaml:"recognition_pause"`
+
+	// Telegram settings are used if notifiers aren't configured.
 	TelegramBotToken                string `yaml:"telegram_bot_token"`
 	TelegramNotificationsRecipients []int  `yaml:"telegram_notifications_recipients"`
 
-	FaceAPIConfig face.APIConfig   `yaml:"face_api"`
+	Notifiers []notify.SinkConfig `yaml:"notifiers"`
+	Outbox    notify.OutboxConfig `yaml:"outbox"`
+
+	TelegramBot telegramBotConfig `yaml:"telegram_bot"`
+
+	FaceAPIConfig face.Config      `yaml:"face_api"`
 	WebServer     web.ServerConfig `yaml:"web_server"`
 }
 
@@ -54,6 +86,16 @@ type serviceConfig struct {
 	ProcessPeriod           time.Duration
 	CheckOnlineTimeout      time.Duration
 	CheckAgentOnlineTimeout time.Duration
+	LivenessInterval        time.Duration
+	LivenessCheckInterval   time.Duration
+	RecognitionPause        time.Duration
+	FrameReuseMaxAge        time.Duration
+
+	AdaptiveEnrollmentInterval time.Duration
+	CorrelationWindow          time.Duration
+
+	AlertingSuppressWindow   time.Duration
+	AlertingReminderInterval time.Duration
 }
 
 func (c *serviceConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
@@ -68,22 +110,197 @@ func (c *serviceConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 
 	c.ProcessPeriod, err = time.ParseDuration(cRaw.ProcessPeriod)
 	if err != nil {
-		return fmt.Errorf("parse process_duration: %w", err)
+		return fmt.Errorf("parse process_period: %w", err)
 	}
 
-	c.CheckOnlineTimeout, err = time.ParseDuration(cRaw.CheckAgentOnlineTimeout)
+	c.CheckOnlineTimeout, err = time.ParseDuration(cRaw.CheckOnlineTimeout)
 	if err != nil {
-		return fmt.Errorf("parse process_duration: %w", err)
+		return fmt.Errorf("parse check_online_timeout: %w", err)
 	}
 
 	c.CheckAgentOnlineTimeout, err = time.ParseDuration(cRaw.CheckAgentOnlineTimeout)
 	if err != nil {
-		return fmt.Errorf("parse process_duration: %w", err)
+		return fmt.Errorf("parse check_agent_online_timeout: %w", err)
+	}
+
+	if c.AcceptanceThreshold == 0 {
+		c.AcceptanceThreshold = defaultAcceptanceThreshold
+	}
+
+	if c.RejectionThreshold == 0 {
+		c.RejectionThreshold = defaultRejectionThreshold
+	}
+
+	if c.RejectionThreshold > c.AcceptanceThreshold {
+		return fmt.Errorf("rejection_threshold is greater than acceptance_threshold")
+	}
+
+	err = parseAction("bystander_action", &c.BystanderAction, actionNotify,
+		actionIgnore, actionNotify, actionLock)
+	if err != nil {
+		return err
+	}
+
+	if c.Liveness.Frames == 0 {
+		c.Liveness.Frames = defaultLivenessFrames
+	}
+
+	if c.Liveness.Interval == "" {
+		c.Liveness.Interval = defaultLivenessInterval
+	}
+
+	c.LivenessInterval, err = time.ParseDuration(c.Liveness.Interval)
+	if err != nil {
+		return fmt.Errorf("parse liveness interval: %w", err)
+	}
+
+	if c.Liveness.CheckInterval == "" {
+		c.Liveness.CheckInterval = defaultLivenessCheckInterval
+	}
+
+	c.LivenessCheckInterval, err = time.ParseDuration(c.Liveness.CheckInterval)
+	if err != nil {
+		return fmt.Errorf("parse liveness check_interval: %w", err)
+	}
+
+	err = parseAction("liveness action", &c.Liveness.Action, actionLogout,
+		actionNotify, actionLock, actionLogout)
+	if err != nil {
+		return err
+	}
+
+	if c.FrameReuse.MaxAge == "" {
+		c.FrameReuse.MaxAge = defaultFrameReuseMaxAge
+	}
+
+	c.FrameReuseMaxAge, err = time.ParseDuration(c.FrameReuse.MaxAge)
+	if err != nil {
+		return fmt.Errorf("parse frame_reuse max_age: %w", err)
+	}
+
+	if c.FrameReuse.MaxHashDistance == 0 {
+		c.FrameReuse.MaxHashDistance = defaultFrameReuseMaxHashDistance
+	}
+
+	if c.FrameReuse.MaxDifference == 0 {
+		c.FrameReuse.MaxDifference = defaultFrameReuseMaxDifference
+	}
+
+	err = parseAction("camera_tampering action", &c.CameraTampering.Action, actionNotify,
+		actionNotify, actionLock, actionLogout)
+	if err != nil {
+		return err
+	}
+
+	if c.Correlation.Window == "" {
+		c.Correlation.Window = defaultCorrelationWindow
+	}
+
+	c.CorrelationWindow, err = time.ParseDuration(c.Correlation.Window)
+	if err != nil {
+		return fmt.Errorf("parse correlation window: %w", err)
+	}
+
+	if c.Alerting.SuppressWindow == "" {
+		c.Alerting.SuppressWindow = defaultAlertingSuppressWindow
+	}
+
+	c.AlertingSuppressWindow, err = time.ParseDuration(c.Alerting.SuppressWindow)
+	if err != nil {
+		return fmt.Errorf("parse alerting suppress_window: %w", err)
+	}
+
+	if c.Alerting.ReminderInterval == "" {
+		c.Alerting.ReminderInterval = defaultAlertingReminderInterval
+	}
+
+	c.AlertingReminderInterval, err = time.ParseDuration(c.Alerting.ReminderInterval)
+	if err != nil {
+		return fmt.Errorf("parse alerting reminder_interval: %w", err)
+	}
+
+	if c.AdaptiveEnrollment.DirectoryPath == "" {
+		c.AdaptiveEnrollment.DirectoryPath = defaultAdaptiveEnrollmentDirectoryPath
+	}
+
+	if c.AdaptiveEnrollment.MinScore == 0 {
+		c.AdaptiveEnrollment.MinScore = defaultAdaptiveEnrollmentMinScore
+	}
+
+	if c.AdaptiveEnrollment.Interval == "" {
+		c.AdaptiveEnrollment.Interval = defaultAdaptiveEnrollmentInterval
+	}
+
+	c.AdaptiveEnrollmentInterval, err = time.ParseDuration(c.AdaptiveEnrollment.Interval)
+	if err != nil {
+		return fmt.Errorf("parse adaptive_enrollment interval: %w", err)
+	}
+
+	if c.AdaptiveEnrollment.MaxPhotosPerUser == 0 {
+		c.AdaptiveEnrollment.MaxPhotosPerUser = defaultAdaptiveEnrollmentMaxPhotosPerUser
+	}
+
+	if c.HostGroups == nil {
+		c.HostGroups = map[string]hostGroupConfig{}
+	}
+
+	if _, exists := c.HostGroups[defaultHostGroup]; !exists {
+		c.HostGroups[defaultHostGroup] = hostGroupConfig{}
+	}
+
+	for name, hg := range c.HostGroups {
+		err = hg.setDefaults()
+		if err != nil {
+			return fmt.Errorf("host group %s: %w", name, err)
+		}
+		c.HostGroups[name] = hg
+	}
+
+	if c.AuditLogPath == "" {
+		c.AuditLogPath = defaultAuditLogPath
+	}
+
+	if c.TelegramBot.Token == "" {
+		c.TelegramBot.Token = c.TelegramBotToken
+	}
+
+	if len(c.TelegramBot.Operators) == 0 {
+		c.TelegramBot.Operators = c.TelegramNotificationsRecipients
+	}
+
+	if c.TelegramBot.Enabled && c.TelegramBot.Token == "" {
+		return fmt.Errorf("telegram_bot token is not set")
+	}
+
+	if c.Outbox.DirectoryPath == "" {
+		c.Outbox.DirectoryPath = defaultOutboxDirectoryPath
+	}
+
+	if cRaw.RecognitionPause == "" {
+		cRaw.RecognitionPause = defaultRecognitionPause
+	}
+
+	c.RecognitionPause, err = time.ParseDuration(cRaw.RecognitionPause)
+	if err != nil {
+		return fmt.Errorf("parse recognition_pause: %w", err)
 	}
 
 	return nil
 }
 
+// thresholds returns host recognition thresholds falling back to the global
+// ones.
+func (c *serviceConfig) thresholds(h entity.Host) (acceptance, rejection float64) {
+	acceptance, rejection = c.AcceptanceThreshold, c.RejectionThreshold
+	if h.AcceptanceThreshold != 0 {
+		acceptance = h.AcceptanceThreshold
+	}
+	if h.RejectionThreshold != 0 {
+		rejection = h.RejectionThreshold
+	}
+	return
+}
+
 type service struct {
 	config serviceConfig
 
@@ -93,9 +310,44 @@ type service struct {
 	hostsStatuses   map[string]entity.HostStatus
 	hostsStatusesMx sync.RWMutex
 
-	tbBot         *telebot.Bot
-	faceAPI       *face.API
-	notifications chan string
+	hostsStates   map[string]*hostState
+	hostsStatesMx sync.Mutex
+
+	// correlations are keys of active cross host correlations, accessed
+	// only by process.
+	correlations map[string]bool
+
+	incidents   []entity.Incident
+	incidentsMx sync.RWMutex
+
+	faceAPIDegraded bool
+
+	pause   recognitionPause
+	pauseMx sync.Mutex
+
+	auditLog       *auditLog
+	faceRecognizer face.Recognizer
+	faceEnroller   face.Enroller
+
+	enrollment     *enrollment.Store
+	enrollmentNext map[string]time.Time
+	enrollmentMx   sync.Mutex
+	enrollmentWg   sync.WaitGroup
+
+	// alerts are ongoing conditions, incidents are ongoing conditions which
+	// raise incidents, sent are recently sent notifications keys and mutes
+	// are hosts with disabled notifications.
+	alerts         map[alertKey]*alert
+	incidentStates map[alertKey]bool
+	sent           map[string]time.Time
+	mutes          map[string]time.Time
+	alertsMx       sync.Mutex
+
+	notifier *notify.Router
+	outbox   *notify.Outbox
+	bot      *operatorBot
+
+	webServer *web.Server
 }
 
 func (s *service) AgentConfig(hostName string) (entity.AgentConfig, error) {
@@ -214,15 +466,6 @@ func (s *service) loadHosts() error {
 	return nil
 }
 
-func (s *service) notify(notification string) {
-	for _, r := range s.config.TelegramNotificationsRecipients {
-		_, err := s.tbBot.Send(&telebot.User{ID: r}, notification)
-		if err != nil {
-			eLog.Error(1, fmt.Sprintf("не удалось отправить телеграм оповещеение: %v", err))
-		}
-	}
-}
-
 func (s *service) loadConfig(exePath string) error {
 	f, err := os.Open(path.Join(exePath, "overseer.conf"))
 	if err != nil {
@@ -232,116 +475,163 @@ func (s *service) loadConfig(exePath string) error {
 	return yaml.NewDecoder(f).Decode(&s.config)
 }
 
-func (s *service) Execute(args []string, changeRequests <-chan svc.ChangeRequest, statusChanges chan<- svc.Status) (ssec bool, errno uint32) {
-
-	statusChanges <- svc.Status{State: svc.StartPending}
-
-	exe, err := os.Executable()
-	if err != nil {
-		errno = 1
-		return
-	}
-
-	exePath := filepath.Dir(exe)
-
-	err = s.loadConfig(exePath)
+// start loads config from the config directory and starts service
+// components. Non zero exit code is returned on failure.
+func (s *service) start(configDirPath string) uint32 {
+	err := s.loadConfig(configDirPath)
 	if err != nil {
 		eLog.Error(1, fmt.Sprintf("не удалось загрузить конфиг: %v", err))
-		errno = 2
-		return
+		return 2
 	}
 
 	if !path.IsAbs(s.config.HostsConfigsDirectoryPath) {
-		s.config.HostsConfigsDirectoryPath = path.Join(exePath, s.config.HostsConfigsDirectoryPath)
+		s.config.HostsConfigsDirectoryPath = path.Join(configDirPath, s.config.HostsConfigsDirectoryPath)
 	}
 
 	err = s.loadHosts()
 	if err != nil {
 		eLog.Error(1, fmt.Sprintf("не удалось загрузить хосты: %v", err))
-		errno = 3
-		return
+		return 3
 	}
 
 	s.hostsStatuses = map[string]entity.HostStatus{}
+	s.hostsStates = map[string]*hostState{}
 
-	s.tbBot, err = telebot.NewBot(telebot.Settings{
-		Token: s.config.TelegramBotToken,
-		Poller: &telebot.LongPoller{
-			Timeout: 10 * time.Second,
-		},
-	})
+	if !path.IsAbs(s.config.AuditLogPath) {
+		s.config.AuditLogPath = path.Join(configDirPath, s.config.AuditLogPath)
+	}
 
-	s.tbBot.Handle(telebot.OnText, func(m *telebot.Message) {
-		s.tbBot.Reply(m, fmt.Sprintf("Ваш ID пользователя %d.", m.Sender.ID))
-	})
+	s.auditLog, err = openAuditLog(s.config.AuditLogPath)
+	if err != nil {
+		eLog.Error(1, fmt.Sprintf("не удалось открыть журнал аудита: %v", err))
+		return 6
+	}
 
-	s.faceAPI = face.NewAPI(s.config.FaceAPIConfig)
+	s.notifier, err = notify.New(s.config.notifierSinksConfigs())
+	if err != nil {
+		eLog.Error(1, fmt.Sprintf("не удалось создать оповещатель: %v", err))
+		s.auditLog.Close()
+		return 8
+	}
 
-	s.notifications = make(chan string)
+	faceBackend, err := face.New(s.config.FaceAPIConfig)
+	if err != nil {
+		eLog.Error(1, fmt.Sprintf("не удалось создать face API: %v", err))
+		s.auditLog.Close()
+		return 5
+	}
 
-	var wg sync.WaitGroup
+	s.faceRecognizer, s.faceEnroller = faceBackend, faceBackend
 
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		for n := range s.notifications {
-			s.notify(n)
+	if s.config.AdaptiveEnrollment.Enabled {
+		if !path.IsAbs(s.config.AdaptiveEnrollment.DirectoryPath) {
+			s.config.AdaptiveEnrollment.DirectoryPath = path.Join(configDirPath, s.config.AdaptiveEnrollment.DirectoryPath)
 		}
-	}()
 
-	ws, err := web.NewServer(s.config.WebServer, s, s)
+		s.enrollment, err = enrollment.Open(s.config.AdaptiveEnrollment.DirectoryPath)
+		if err != nil {
+			eLog.Error(1, fmt.Sprintf("не удалось открыть хранилище фотографий для дообучения: %v", err))
+			face.Close(faceBackend)
+			s.auditLog.Close()
+			return 7
+		}
+
+		s.enrollmentNext = map[string]time.Time{}
+	}
+
+	s.alerts = map[alertKey]*alert{}
+	s.incidentStates = map[alertKey]bool{}
+	s.sent = map[string]time.Time{}
+	s.mutes = map[string]time.Time{}
+
+	if !path.IsAbs(s.config.Outbox.DirectoryPath) {
+		s.config.Outbox.DirectoryPath = path.Join(configDirPath, s.config.Outbox.DirectoryPath)
+	}
+
+	s.outbox, err = notify.OpenOutbox(s.config.Outbox, s.notifier, s.deliveryFailed)
+	if err != nil {
+		eLog.Error(1, fmt.Sprintf("не удалось открыть очередь оповещений: %v", err))
+		face.Close(faceBackend)
+		s.auditLog.Close()
+		return 9
+	}
+
+	s.webServer, err = web.NewServer(s.config.WebServer, s, s, s, s)
 	if err != nil {
 		eLog.Error(1, fmt.Sprintf("не удалось запустить веб-сервер: %v", err))
-		errno = 4
-		return
+		s.outbox.Close()
+		face.Close(faceBackend)
+		s.auditLog.Close()
+		return 4
+	}
+
+	if s.config.TelegramBot.Token != "" {
+		s.bot = newOperatorBot(s, s.config.TelegramBot)
+		s.bot.start()
 	}
 
+	return 0
+}
+
+// run processes hosts every process period until context is done.
+func (s *service) run(ctx context.Context) {
 	ticker := time.NewTicker(s.config.ProcessPeriod)
 	defer ticker.Stop()
 
-	statusChanges <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
-
-loop:
 	for {
 		select {
 		case <-ticker.C:
-			s.process()
-		case cr := <-changeRequests:
-			switch cr.Cmd {
-			case svc.Interrogate:
-				statusChanges <- cr.CurrentStatus
-			case svc.Stop, svc.Shutdown:
-				eLog.Info(1, "получен запрос остановки, останавливаю")
-				break loop
-			default:
-				eLog.Error(1, fmt.Sprintf("неизвестный управляющий запрос #%d", cr))
-			}
+			s.process(ctx)
+		case <-ctx.Done():
+			return
 		}
 	}
+}
 
-	statusChanges <- svc.Status{State: svc.StopPending}
+// stop stops service components started by start.
+func (s *service) stop() {
+	// Photos uploads are interrupted by run context.
+	s.enrollmentWg.Wait()
 
-	err = ws.Close()
+	err := s.webServer.Close()
 	if err != nil {
 		eLog.Error(1, fmt.Sprintf("не удалось остановить веб-сервер: %v", err))
 	}
 
-	close(s.notifications)
+	if s.bot != nil {
+		s.bot.close()
+	}
 
-	wg.Wait()
+	s.outbox.Close()
 
-	statusChanges <- svc.Status{State: svc.Stopped}
+	err = face.Close(s.faceRecognizer)
+	if err != nil {
+		eLog.Error(1, fmt.Sprintf("не удалось закрыть face API: %v", err))
+	}
 
-	return
+	s.auditLog.Close()
 }
 
-func (s *service) processHost(h entity.Host) {
+func (s *service) processHost(ctx context.Context, h entity.Host) {
+	// Service is stopping, host is left as is.
+	if ctx.Err() != nil {
+		return
+	}
+
+	st := s.hostState(h.Name)
+
 	var (
-		online      bool
-		agentOnline bool
-		cameraFrame io.ReadCloser
-		activeUser  string
-		err         error
+		online           bool
+		agentOnline      bool
+		cameraFrame      io.ReadCloser
+		activeUser       string
+		recognizedUserID string
+		score            float64
+		outcome          entity.RecognitionOutcome
+		facesCount       int
+		errorDecision    string
+		errorCategory    face.ErrorCategory
+		err              error
 	)
 
 	defer func() {
@@ -354,11 +644,25 @@ func (s *service) processHost(h entity.Host) {
 		}
 
 		s.hostsStatuses[h.Name] = entity.HostStatus{
-			Online:      online,
-			AgentOnline: agentOnline,
-			ActiveUser:  activeUser,
-			UpdatedAt:   time.Now(),
-			Error:       errMsg,
+			Name:                 h.Name,
+			Online:               online,
+			AgentOnline:          agentOnline,
+			ActiveUser:           activeUser,
+			RecognizedUserID:     recognizedUserID,
+			Score:                score,
+			Outcome:              outcome,
+			FacesCount:           facesCount,
+			FaceAPIDegraded:      s.faceAPIDegraded,
+			RecognitionErrors:    st.recognitionErrors,
+			ErrorDecision:        errorDecision,
+			ErrorCategory:        string(errorCategory),
+			FrameReuseHits:       st.frameReuseHits,
+			FrameReuseMisses:     st.frameReuseMisses,
+			AbsentSince:          st.absence.since,
+			UnknownFaceStrikes:   st.strikesCount(strikeUnknownFace),
+			DifferentUserStrikes: st.strikesCount(strikeDifferentUser),
+			UpdatedAt:            time.Now(),
+			Error:                errMsg,
 		}
 	}()
 
@@ -371,10 +675,16 @@ func (s *service) processHost(h entity.Host) {
 	agentOnline = h.CheckAgentOnline(s.config.CheckAgentOnlineTimeout)
 	if !agentOnline {
 		eLog.Info(1, fmt.Sprintf("[имя_хоста=%s] агент выключен", h.Name))
-		s.notifications <- fmt.Sprintf("[имя_хоста=%s] агент выключен", h.Name)
+		s.alert(notify.Notification{
+			Type:     notificationAgentOffline,
+			HostName: h.Name,
+			Message:  fmt.Sprintf("[имя_хоста=%s] агент выключен", h.Name),
+		})
 		return
 	}
 
+	s.resolve(notificationAgentOffline, h.Name, fmt.Sprintf("[имя_хоста=%s] агент снова включен", h.Name))
+
 	cameraFrame, activeUser, err = h.Status()
 
 	if cameraFrame != nil {
@@ -383,37 +693,263 @@ func (s *service) processHost(h entity.Host) {
 
 	if err != nil {
 		eLog.Error(1, fmt.Sprintf("[имя_хоста=%s] не удалось получить статус агента: %v", h.Name, err))
-		s.notifications <- fmt.Sprintf("[имя_хоста=%s] не удалось получить статус агента", h.Name)
+		s.alert(notify.Notification{
+			Type:     notificationAgentStatusFailed,
+			HostName: h.Name,
+			Message:  fmt.Sprintf("[имя_хоста=%s] не удалось получить статус агента", h.Name),
+		})
 		return
 	}
 
+	s.resolve(notificationAgentStatusFailed, h.Name, fmt.Sprintf("[имя_хоста=%s] статус агента снова получен", h.Name))
+
 	if activeUser == "" {
+		st.resetAbsence()
+		st.resetStrikes()
+		st.liveness = livenessResult{}
 		eLog.Error(1, fmt.Sprintf("[имя_хоста=%s] нет залогиненного пользователя", h.Name))
 		return
 	}
 
-	recognizedUserID, err := s.faceAPI.RecognizeUser(cameraFrame)
+	frame, err := ioutil.ReadAll(cameraFrame)
+	if err != nil {
+		eLog.Error(1, fmt.Sprintf("[имя_хоста=%s, имя_пользователя=%s] не удалось прочитать кадр камеры: %v", h.Name, activeUser, err))
+		return
+	}
+
+	// Frame which can't be decoded is left to face API.
+	img, _, imgErr := image.Decode(bytes.NewReader(frame))
+	if imgErr != nil {
+		img = nil
+	}
+
+	if s.config.CameraTampering.Enabled && img != nil && s.checkCameraTampering(h, st, activeUser, img) {
+		outcome = entity.OutcomeCameraTampered
+		return
+	}
+
+	var signature face.Signature
+
+	if s.config.FrameReuse.Enabled {
+		var (
+			v  verifiedResult
+			ok bool
+		)
+		v, signature, ok = s.reusableResult(st, img, activeUser)
+		if ok {
+			st.frameReuseHits++
+			recognizedUserID, score, facesCount = v.recognizedUserID, v.score, v.facesCount
+			eLog.Info(1, fmt.Sprintf("[имя_хоста=%s, имя_пользователя=%s] кадр не изменился, используется результат распознавания от %s", h.Name, activeUser, v.verifiedAt.Format(time.RFC3339)))
+			acceptance, _ := s.config.thresholds(h)
+			s.checkBystanders(h, activeUser, v.bystanders, acceptance)
+			outcome, err = s.livenessOutcome(h, st, activeUser, v.box)
+			if outcome != entity.OutcomeAuthorized {
+				st.verified = nil
+			}
+			return
+		}
+		st.frameReuseMisses++
+	}
+
+	if category, paused := s.recognitionPaused(); paused {
+		outcome = entity.OutcomePaused
+		errorCategory = category
+		eLog.Info(1, fmt.Sprintf("[имя_хоста=%s, имя_пользователя=%s, категория_ошибки=%s] распознавание приостановлено", h.Name, activeUser, category))
+		return
+	}
+
+	if ctx.Err() != nil {
+		return
+	}
+
+	recognitions, err := face.RecognizeUsers(ctx, s.faceRecognizer, bytes.NewReader(frame))
 	if err != nil {
-		if err == face.ErrFaceNotFound {
+		// Recognition interrupted by the service stop is not a recognition
+		// failure, so error policy must not log users out.
+		if ctx.Err() != nil {
+			err = nil
+			return
+		}
+		if errors.Is(err, face.ErrFaceNotFound) {
+			st.recognitionErrors = 0
+			s.resolveRecognitionFailed(h, activeUser)
+			outcome = entity.OutcomeNoFace
 			eLog.Info(1, fmt.Sprintf("[имя_хоста=%s, имя_пользователя=%s] не удалось распознать лицо пользователя: нет лица в кадре", h.Name, activeUser))
+			s.handleAbsence(h, st, activeUser)
 			return
 		}
-		eLog.Error(1, fmt.Sprintf("[имя_хоста=%s, имя_пользователя=%s] не удалось распознать лицо пользователя: %v", h.Name, activeUser, err))
-		s.notifications <- fmt.Sprintf("[имя_хоста=%s, имя_пользователя=%s] не удалось распознать лицо пользователя", h.Name, activeUser)
+		errorCategory = face.Category(err)
+		errorDecision = s.handleRecognitionError(h, st, activeUser, err)
+		outcome = entity.OutcomeError
+		return
 	}
 
-	if recognizedUserID == "" || h.Users[activeUser] != recognizedUserID {
-		eLog.Error(1, fmt.Sprintf("[имя_хоста=%s, имя_пользователя=%s, идентификатор_обнаруженного_пользователя=%s] обнаружен неразрешённый пользователь", h.Name, activeUser, recognizedUserID))
-		s.notifications <- fmt.Sprintf("[имя_хоста=%s, имя_пользователя=%s] обнаружен неразрешённый пользователь", h.Name, activeUser)
-		err = h.LogoutCurrentUser()
-		if err != nil {
-			eLog.Error(1, fmt.Sprintf("[имя_хоста=%s, имя_пользователя=%s] не удалось разлогинить неразрешённого пользователя: %v", h.Name, activeUser, err))
-			s.notifications <- fmt.Sprintf("[имя_хоста=%s, имя_пользователя=%s] не удалось разлогинить неразрешённого пользователя", h.Name, activeUser)
+	st.recognitionErrors = 0
+	s.resolveRecognitionFailed(h, activeUser)
+	st.resetAbsence()
+
+	acceptance, rejection := s.config.thresholds(h)
+
+	var recognition face.Recognition
+
+	if len(recognitions) > 0 {
+		recognition = recognitions[0]
+		facesCount = len(recognitions)
+		s.checkBystanders(h, activeUser, recognitions[1:], acceptance)
+	}
+
+	best, _ := recognition.Best()
+	recognizedUserID, score = best.UserID, best.Score
+
+	activeUserID, allowed := h.Users[activeUser]
+
+	switch {
+	case allowed && recognition.Score(activeUserID) >= acceptance:
+		recognizedUserID, score = activeUserID, recognition.Score(activeUserID)
+		if s.config.hostGroup(h).Strikes.ResetOnMatch {
+			st.resetStrikes()
 		}
+		outcome, err = s.livenessOutcome(h, st, activeUser, recognition.Box)
+		if s.config.AdaptiveEnrollment.Enabled && outcome == entity.OutcomeAuthorized && facesCount == 1 {
+			s.collectEnrollmentPhoto(ctx, h, activeUser, activeUserID, score, frame)
+		}
+		if s.config.FrameReuse.Enabled && outcome == entity.OutcomeAuthorized && img != nil {
+			st.verified = &verifiedResult{
+				signature:        signature,
+				activeUser:       activeUser,
+				recognizedUserID: recognizedUserID,
+				score:            score,
+				facesCount:       facesCount,
+				box:              recognition.Box,
+				bystanders:       recognitions[1:],
+				localFaces:       len(face.Detect(img)),
+				verifiedAt:       time.Now(),
+			}
+		}
+		return
+	case best.UserID != "" && best.Score < acceptance && best.Score >= rejection:
+		outcome = entity.OutcomeUncertain
+		eLog.Warning(1, fmt.Sprintf("[имя_хоста=%s, имя_пользователя=%s, идентификатор_обнаруженного_пользователя=%s, оценка=%.2f] пользователь распознан неуверенно", h.Name, activeUser, recognizedUserID, score))
+		return
+	}
+
+	outcome = entity.OutcomeUnauthorized
+
+	kind := strikeUnknownFace
+	if best.UserID != "" && best.Score >= acceptance {
+		kind = strikeDifferentUser
+		// Account owner works on the host they are not allowed to use.
+		if !allowed && best.UserID == s.userID(activeUser) {
+			kind = strikeNotAllowedUser
+		}
+	}
+
+	strikesConfig := s.config.hostGroup(h).Strikes
+
+	if !st.strike(activeUser, kind, strikesConfig) {
+		eLog.Warning(1, fmt.Sprintf("[имя_хоста=%s, имя_пользователя=%s, идентификатор_обнаруженного_пользователя=%s, оценка=%.2f, тип=%s, предупреждений=%d/%d] кадр не совпал с пользователем", h.Name, activeUser, recognizedUserID, score, kind, st.strikesCount(kind), strikesConfig.threshold(kind)))
+		return
 	}
+
+	s.handleMismatch(h, st, activeUser, kind, recognizedUserID, score, img, recognitions)
 }
 
-func (s *service) process() {
+// handleRecognitionError reacts to recognition error according to its
+// category and returns the decision made. Provider failures are handled by
+// host group recognition error policy.
+func (s *service) handleRecognitionError(h entity.Host, st *hostState, activeUser string, err error) string {
+	category := face.Category(err)
+
+	switch category {
+	case face.CategoryQuotaExceeded, face.CategoryUnauthorized:
+		if s.pauseRecognition(category) {
+			eLog.Error(1, fmt.Sprintf("[имя_хоста=%s, имя_пользователя=%s, категория_ошибки=%s] распознавание приостановлено на %s: %v", h.Name, activeUser, category, s.config.RecognitionPause, err))
+			s.notify(notify.Notification{
+				Type:      notificationRecognitionPaused,
+				Condition: string(category),
+				Message:   fmt.Sprintf("[категория_ошибки=%s] распознавание приостановлено на %s", category, s.config.RecognitionPause),
+			})
+			s.audit("recognition_paused", h.Name, activeUser, map[string]interface{}{
+				"error":    err.Error(),
+				"category": category,
+				"pause":    s.config.RecognitionPause.String(),
+			})
+		}
+		return errorDecisionPause
+
+	case face.CategoryInvalidImage, face.CategoryInvalidRequest:
+		eLog.Error(1, fmt.Sprintf("[имя_хоста=%s, имя_пользователя=%s, категория_ошибки=%s] не удалось распознать лицо пользователя: %v", h.Name, activeUser, category, err))
+		s.alert(notify.Notification{
+			Type:     notificationRecognitionFailed,
+			HostName: h.Name,
+			UserName: activeUser,
+			Message:  fmt.Sprintf("[имя_хоста=%s, имя_пользователя=%s, категория_ошибки=%s] не удалось распознать лицо пользователя: некорректный кадр", h.Name, activeUser, category),
+		})
+		return errorDecisionKeepSession
+	}
+
+	st.recognitionErrors++
+
+	hg := s.config.hostGroup(h)
+	decision := hg.recognitionErrorDecision(st.recognitionErrors)
+
+	if errors.Is(err, face.ErrCircuitOpen) {
+		// Degradation is notified once by checkFaceAPIDegraded.
+		eLog.Info(1, fmt.Sprintf("[имя_хоста=%s, имя_пользователя=%s, решение=%s] не удалось распознать лицо пользователя: face API деградировал", h.Name, activeUser, decision))
+	} else {
+		eLog.Error(1, fmt.Sprintf("[имя_хоста=%s, имя_пользователя=%s, категория_ошибки=%s, решение=%s] не удалось распознать лицо пользователя: %v", h.Name, activeUser, category, decision, err))
+		s.alert(notify.Notification{
+			Type:     notificationRecognitionFailed,
+			HostName: h.Name,
+			UserName: activeUser,
+			Message:  fmt.Sprintf("[имя_хоста=%s, имя_пользователя=%s, категория_ошибки=%s, решение=%s] не удалось распознать лицо пользователя", h.Name, activeUser, category, decision),
+		})
+	}
+
+	s.audit("recognition_error", h.Name, activeUser, map[string]interface{}{
+		"error":              err.Error(),
+		"category":           category,
+		"policy":             hg.RecognitionErrorPolicy,
+		"consecutive_errors": st.recognitionErrors,
+		"decision":           decision,
+	})
+
+	if decision == errorDecisionLogout {
+		s.enforce(h, activeUser, actionLogout)
+	}
+
+	return decision
+}
+
+// checkFaceAPIDegraded logs face API degradation and recovery once per state
+// change and alerts about degradation until recovery.
+func (s *service) checkFaceAPIDegraded() {
+	d, ok := s.faceRecognizer.(face.Degradable)
+	if !ok {
+		return
+	}
+
+	degraded := d.Degraded()
+	if degraded != s.faceAPIDegraded {
+		s.faceAPIDegraded = degraded
+		if degraded {
+			eLog.Error(1, "face API деградировал, распознавание приостановлено")
+		} else {
+			eLog.Info(1, "face API восстановился")
+		}
+	}
+
+	if degraded {
+		s.alert(notify.Notification{
+			Type:    notificationFaceAPIDegraded,
+			Message: "face API деградировал, распознавание приостановлено",
+		})
+	} else {
+		s.resolve(notificationFaceAPIDegraded, "", "face API восстановился")
+	}
+}
+
+func (s *service) process(ctx context.Context) {
 	var wg sync.WaitGroup
 	hosts := make(chan entity.Host)
 
@@ -427,43 +963,27 @@ func (s *service) process() {
 		go func() {
 			defer wg.Done()
 			for h := range hosts {
-				s.processHost(h)
+				s.processHost(ctx, h)
 			}
 		}()
 	}
 
-	s.hostsMx.RLock()
-	defer s.hostsMx.RUnlock()
-
-	for _, h := range s.hosts {
+	// Workers skip hosts when service is stopping.
+	for _, h := range s.Hosts() {
 		hosts <- h
 	}
 
 	close(hosts)
 	wg.Wait()
-}
 
-func runService(name string, isDebug bool) {
-	var err error
-	if isDebug {
-		eLog = debug.New(name)
-	} else {
-		eLog, err = eventlog.Open(name)
-		if err != nil {
-			return
-		}
+	if ctx.Err() != nil {
+		return
 	}
-	defer eLog.Close()
 
-	eLog.Info(1, fmt.Sprintf("starting %s service", name))
-	run := svc.Run
-	if isDebug {
-		run = debug.Run
-	}
-	err = run(name, &service{})
-	if err != nil {
-		eLog.Error(1, fmt.Sprintf("%s service failed: %v", name, err))
-		return
+	s.checkFaceAPIDegraded()
+	s.checkRecognitionPause()
+
+	if s.config.Correlation.Enabled {
+		s.correlate()
 	}
-	eLog.Info(1, fmt.Sprintf("%s service stopped", name))
 }
@@ -0,0 +1,627 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/dimuls/oko/entity"
+	"github.com/dimuls/oko/face"
+	"github.com/dimuls/oko/face/facetest"
+	"github.com/dimuls/oko/overseer/notify"
+)
+
+// fakeAgent serves camera frame of the active user and counts actions.
+type fakeAgent struct {
+	server *httptest.Server
+
+	mx         sync.Mutex
+	activeUser string
+	frame      []byte
+	logouts    int
+	locks      int
+}
+
+func newFakeAgent(activeUser string, frame []byte) *fakeAgent {
+	a := &fakeAgent{activeUser: activeUser, frame: frame}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		a.mx.Lock()
+		defer a.mx.Unlock()
+		w.Header().Set("X-Active-User", base64.StdEncoding.EncodeToString([]byte(a.activeUser)))
+		w.Write(a.frame)
+	})
+
+	mux.HandleFunc("/logout", func(w http.ResponseWriter, r *http.Request) {
+		a.mx.Lock()
+		a.logouts++
+		a.mx.Unlock()
+	})
+
+	mux.HandleFunc("/lock", func(w http.ResponseWriter, r *http.Request) {
+		a.mx.Lock()
+		a.locks++
+		a.mx.Unlock()
+	})
+
+	a.server = httptest.NewServer(mux)
+
+	return a
+}
+
+func (a *fakeAgent) host(t *testing.T, users map[string]string) entity.Host {
+	_, port, err := net.SplitHostPort(a.server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return entity.Host{
+		Name:            "127.0.0.1",
+		OnlineCheckPort: p,
+		AgentPort:       p,
+		Users:           users,
+	}
+}
+
+func (a *fakeAgent) actions() (logouts, locks int) {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	return a.logouts, a.locks
+}
+
+const discardNotifierType = "discard"
+
+// discardNotifier accepts every notification, so notifications are counted
+// by outbox deliveries.
+type discardNotifier struct{}
+
+func (discardNotifier) Notify(ctx context.Context, n notify.Notification) error {
+	return nil
+}
+
+func init() {
+	notify.Register(discardNotifierType, func(decode func(interface{}) error) (notify.Notifier, error) {
+		return discardNotifier{}, nil
+	})
+}
+
+const testServiceConfig = `
+process_period: 1s
+process_concurrency: 1
+check_online_timeout: 1s
+check_agent_online_timeout: 1s
+`
+
+// newTestService creates service of the hosts with the given config appended
+// to the test service config using face API served by fs. Notifications are delivered
+// to the discard notifier.
+func newTestService(t *testing.T, config string, fs *facetest.Server, hosts ...entity.Host) *service {
+	eLog = newJournalLog(ioutil.Discard)
+
+	dirPath, err := ioutil.TempDir("", "overseer-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dirPath) })
+
+	s := &service{
+		hosts:          map[string]entity.Host{},
+		hostsStatuses:  map[string]entity.HostStatus{},
+		hostsStates:    map[string]*hostState{},
+		alerts:         map[alertKey]*alert{},
+		sent:           map[string]time.Time{},
+		incidentStates: map[alertKey]bool{},
+	}
+
+	err = yaml.Unmarshal([]byte(testServiceConfig+config), &s.config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Hosts of the same fake agent get distinct names.
+	for i, h := range hosts {
+		if i > 0 {
+			h.Name += "." + strconv.Itoa(i)
+		}
+		s.hosts[h.Name] = h
+	}
+
+	s.auditLog, err = openAuditLog(filepath.Join(dirPath, "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.auditLog.Close() })
+
+	s.notifier, err = notify.New([]notify.SinkConfig{{Type: discardNotifierType}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.outbox, err = notify.OpenOutbox(notify.OutboxConfig{DirectoryPath: filepath.Join(dirPath, "outbox")}, s.notifier, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.outbox.Close() })
+
+	api, err := face.NewAPI(fs.Config())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.faceRecognizer, s.faceEnroller = api, api
+
+	return s
+}
+
+func incidentTypes(s *service) []entity.IncidentType {
+	var ts []entity.IncidentType
+	for _, i := range s.Incidents() {
+		ts = append(ts, i.Type)
+	}
+	return ts
+}
+
+func TestProcessHost(t *testing.T) {
+	frame := []byte("camera frame")
+
+	mainFace := face.Recognition{
+		Box:        image.Rect(0, 0, 100, 100),
+		Candidates: []face.Candidate{{UserID: "user-1", Score: 1}},
+	}
+
+	bystander := face.Recognition{Box: image.Rect(100, 0, 150, 50)}
+
+	tests := []struct {
+		name string
+		// users are users of the processed host, otherUsers are users of the
+		// other host.
+		users      map[string]string
+		otherUsers map[string]string
+		// frameUserID is a user the frame is enrolled for, empty means
+		// unknown person.
+		frameUserID string
+		setup       func(fs *facetest.Server)
+		// config is appended to the test service config.
+		config string
+		// cancel stops service during recognition.
+		cancel            bool
+		wantOutcome       entity.RecognitionOutcome
+		wantLogouts       int
+		wantIncidents     []entity.IncidentType
+		wantLocks         int
+		wantNotifications int
+	}{
+		{
+			name:        "authorized",
+			users:       map[string]string{"ivan": "user-1"},
+			frameUserID: "user-1",
+			wantOutcome: entity.OutcomeAuthorized,
+		},
+		{
+			name:        "uncertain",
+			users:       map[string]string{"ivan": "user-1"},
+			frameUserID: "user-1",
+			setup: func(fs *facetest.Server) {
+				fs.SetCandidates(frame, face.Candidate{UserID: "user-1", Score: 0.6})
+			},
+			wantOutcome: entity.OutcomeUncertain,
+		},
+		{
+			name:        "below rejection threshold",
+			users:       map[string]string{"ivan": "user-1"},
+			frameUserID: "user-1",
+			setup: func(fs *facetest.Server) {
+				fs.SetCandidates(frame, face.Candidate{UserID: "user-1", Score: 0.3})
+			},
+			wantOutcome:       entity.OutcomeUnauthorized,
+			wantLogouts:       1,
+			wantNotifications: 1,
+			wantIncidents:     []entity.IncidentType{entity.IncidentUnknownPerson},
+		},
+		{
+			name:        "bystander",
+			users:       map[string]string{"ivan": "user-1"},
+			frameUserID: "user-1",
+			setup: func(fs *facetest.Server) {
+				fs.SetFaces(frame, mainFace, bystander)
+			},
+			wantOutcome:       entity.OutcomeAuthorized,
+			wantNotifications: 1,
+			wantIncidents:     []entity.IncidentType{entity.IncidentBystanderPresent},
+		},
+		{
+			name:        "bystander lock",
+			users:       map[string]string{"ivan": "user-1"},
+			frameUserID: "user-1",
+			setup: func(fs *facetest.Server) {
+				fs.SetFaces(frame, mainFace, bystander)
+			},
+			config:            "bystander_action: lock\n",
+			wantOutcome:       entity.OutcomeAuthorized,
+			wantLocks:         1,
+			wantNotifications: 1,
+			wantIncidents:     []entity.IncidentType{entity.IncidentBystanderPresent},
+		},
+		{
+			name:        "allowed bystander",
+			users:       map[string]string{"ivan": "user-1", "petr": "user-2"},
+			frameUserID: "user-1",
+			setup: func(fs *facetest.Server) {
+				fs.SetFaces(frame, mainFace, face.Recognition{
+					Box:        image.Rect(0, 0, 50, 50),
+					Candidates: []face.Candidate{{UserID: "user-2", Score: 0.9}},
+				})
+			},
+			config:      "allowed_bystanders: [petr]\n",
+			wantOutcome: entity.OutcomeAuthorized,
+		},
+		{
+			name:              "other user",
+			users:             map[string]string{"ivan": "user-2"},
+			frameUserID:       "user-1",
+			wantOutcome:       entity.OutcomeUnauthorized,
+			wantLogouts:       1,
+			wantNotifications: 1,
+			wantIncidents:     []entity.IncidentType{entity.IncidentCredentialSharing},
+		},
+		{
+			name:  "unknown person",
+			users: map[string]string{"ivan": "user-2"},
+			setup: func(fs *facetest.Server) {
+				fs.Enroll("user-2", []byte("other photo"))
+			},
+			wantOutcome:       entity.OutcomeUnauthorized,
+			wantLogouts:       1,
+			wantNotifications: 1,
+			wantIncidents:     []entity.IncidentType{entity.IncidentUnknownPerson},
+		},
+		{
+			name:              "user not allowed on host",
+			users:             map[string]string{"petr": "user-2"},
+			otherUsers:        map[string]string{"ivan": "user-1"},
+			frameUserID:       "user-1",
+			wantOutcome:       entity.OutcomeUnauthorized,
+			wantIncidents:     []entity.IncidentType{entity.IncidentUserNotAllowed},
+			wantLogouts:       1,
+			wantNotifications: 1,
+		},
+		{
+			name:              "credential sharing notify",
+			users:             map[string]string{"ivan": "user-2"},
+			frameUserID:       "user-1",
+			config:            "host_groups: {default: {credential_sharing_action: notify}}\n",
+			wantOutcome:       entity.OutcomeUnauthorized,
+			wantIncidents:     []entity.IncidentType{entity.IncidentCredentialSharing},
+			wantNotifications: 1,
+		},
+		{
+			name:  "unknown person below strikes threshold",
+			users: map[string]string{"ivan": "user-2"},
+			setup: func(fs *facetest.Server) {
+				fs.Enroll("user-2", []byte("other photo"))
+			},
+			config:      "host_groups: {default: {strikes: {unknown_face: 2}}}\n",
+			wantOutcome: entity.OutcomeUnauthorized,
+		},
+		{
+			name:        "no face",
+			users:       map[string]string{"ivan": "user-1"},
+			frameUserID: "user-1",
+			setup: func(fs *facetest.Server) {
+				fs.MarkFaceless(frame)
+			},
+			wantOutcome: entity.OutcomeNoFace,
+		},
+		{
+			name:        "absent user",
+			users:       map[string]string{"ivan": "user-1"},
+			frameUserID: "user-1",
+			config:      "host_groups: {default: {absence: {logout_after: 1ns}}}\n",
+			setup: func(fs *facetest.Server) {
+				fs.MarkFaceless(frame)
+			},
+			wantOutcome:       entity.OutcomeNoFace,
+			wantIncidents:     []entity.IncidentType{entity.IncidentUserAbsent},
+			wantLogouts:       1,
+			wantNotifications: 1,
+		},
+		{
+			name:        "face API failure fail open",
+			users:       map[string]string{"ivan": "user-1"},
+			frameUserID: "user-1",
+			setup: func(fs *facetest.Server) {
+				fs.SetFailure(http.StatusInternalServerError)
+			},
+			wantOutcome:       entity.OutcomeError,
+			wantNotifications: 1,
+		},
+		{
+			name:        "quota exceeded",
+			users:       map[string]string{"ivan": "user-1"},
+			frameUserID: "user-1",
+			config:      "host_groups: {default: {recognition_error_policy: fail_closed}}\n",
+			setup: func(fs *facetest.Server) {
+				fs.SetFailure(http.StatusTooManyRequests)
+			},
+			wantOutcome:       entity.OutcomeError,
+			wantNotifications: 1,
+		},
+		{
+			name:        "face API failure fail closed",
+			users:       map[string]string{"ivan": "user-1"},
+			frameUserID: "user-1",
+			config:      "host_groups: {default: {recognition_error_policy: fail_closed}}\n",
+			setup: func(fs *facetest.Server) {
+				fs.SetFailure(http.StatusInternalServerError)
+			},
+			wantOutcome:       entity.OutcomeError,
+			wantLogouts:       1,
+			wantNotifications: 1,
+		},
+		{
+			name:        "service stop during recognition",
+			users:       map[string]string{"ivan": "user-1"},
+			frameUserID: "user-1",
+			config:      "host_groups: {default: {recognition_error_policy: fail_closed}}\n",
+			setup: func(fs *facetest.Server) {
+				fs.SetLatency(time.Second)
+			},
+			cancel: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := facetest.NewServer()
+			defer fs.Close()
+
+			if tt.frameUserID != "" {
+				fs.Enroll(tt.frameUserID, frame)
+			}
+
+			if tt.setup != nil {
+				tt.setup(fs)
+			}
+
+			a := newFakeAgent("ivan", frame)
+			defer a.server.Close()
+
+			h := a.host(t, tt.users)
+
+			s := newTestService(t, tt.config, fs, h, a.host(t, tt.otherUsers))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if tt.cancel {
+				time.AfterFunc(100*time.Millisecond, cancel)
+			}
+
+			s.processHost(ctx, h)
+
+			status := s.hostsStatuses[h.Name]
+			if !status.Online || !status.AgentOnline || status.ActiveUser != "ivan" {
+				t.Errorf("status = %+v, want online agent with active user ivan", status)
+			}
+
+			if status.Outcome != tt.wantOutcome {
+				t.Errorf("outcome = %q, want %q", status.Outcome, tt.wantOutcome)
+			}
+
+			its := incidentTypes(s)
+			if len(its) != len(tt.wantIncidents) {
+				t.Fatalf("incidents = %v, want %v", its, tt.wantIncidents)
+			}
+			for i := range its {
+				if its[i] != tt.wantIncidents[i] {
+					t.Errorf("incidents = %v, want %v", its, tt.wantIncidents)
+				}
+			}
+
+			logouts, locks := a.actions()
+
+			if logouts != tt.wantLogouts {
+				t.Errorf("logouts = %d, want %d", logouts, tt.wantLogouts)
+			}
+
+			if locks != tt.wantLocks {
+				t.Errorf("locks = %d, want %d", locks, tt.wantLocks)
+			}
+
+			if got := len(s.NotificationDeliveries()); got != tt.wantNotifications {
+				t.Errorf("notifications = %d, want %d", got, tt.wantNotifications)
+			}
+		})
+	}
+}
+
+// faceFrame returns PNG encoded frame with skin colored faces found by the
+// local face detector.
+func faceFrame(t *testing.T, faces ...image.Rectangle) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 160, 160))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{R: 40, G: 60, B: 90, A: 255}), image.Point{}, draw.Src)
+
+	for _, f := range faces {
+		for y := f.Min.Y; y < f.Max.Y; y++ {
+			for x := f.Min.X; x < f.Max.X; x++ {
+				v := uint8((x*7 + y*13) % 24)
+				img.Set(x, y, color.RGBA{R: 200 + v, G: 150 + v, B: 120 + v, A: 255})
+			}
+		}
+	}
+
+	var b bytes.Buffer
+
+	err := png.Encode(&b, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b.Bytes()
+}
+
+func TestProcessHostCache(t *testing.T) {
+	frame := faceFrame(t, image.Rect(40, 30, 120, 130))
+
+	fs := facetest.NewServer()
+	defer fs.Close()
+
+	fs.Enroll("user-1", frame)
+
+	a := newFakeAgent("ivan", frame)
+	defer a.server.Close()
+
+	h := a.host(t, map[string]string{"ivan": "user-1"})
+
+	s := newTestService(t, "", fs, h)
+
+	dirPath, err := ioutil.TempDir("", "overseer-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dirPath) })
+
+	cached, err := face.NewCached(s.faceRecognizer.(face.Backend), face.CacheConfig{
+		IndexFilePath: filepath.Join(dirPath, "index.json"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.faceRecognizer = cached
+
+	// The first frame is recognized by the backend and learned by the cache,
+	// the same frame is answered by the cache then.
+	wantRequests := []int{1, 1}
+
+	for i, want := range wantRequests {
+		s.processHost(context.Background(), h)
+
+		if outcome := s.hostsStatuses[h.Name].Outcome; outcome != entity.OutcomeAuthorized {
+			t.Errorf("frame #%d outcome = %q, want %q", i, outcome, entity.OutcomeAuthorized)
+		}
+
+		if got := fs.Requests(); got != want {
+			t.Errorf("frame #%d backend requests = %d, want %d", i, got, want)
+		}
+	}
+
+	if stats := cached.Stats(); stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("cache stats = %+v, want 1 hit and 1 miss", stats)
+	}
+}
+
+func TestProcessHostFrameReuse(t *testing.T) {
+	mainFace := image.Rect(40, 30, 120, 130)
+	frame := faceFrame(t, mainFace)
+
+	tests := []struct {
+		name string
+		// nextFrame is a frame of the second tick.
+		nextFrame         []byte
+		wantRequests      int
+		wantReuseHits     int
+		wantIncidents     []entity.IncidentType
+		wantNotifications int
+	}{
+		{
+			name:              "bystander stays",
+			nextFrame:         frame,
+			wantRequests:      1,
+			wantReuseHits:     1,
+			wantIncidents:     []entity.IncidentType{entity.IncidentBystanderPresent},
+			wantNotifications: 1,
+		},
+		{
+			name:              "new face in frame",
+			nextFrame:         faceFrame(t, mainFace, image.Rect(140, 140, 156, 156)),
+			wantRequests:      2,
+			wantIncidents:     []entity.IncidentType{entity.IncidentBystanderPresent},
+			wantNotifications: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := facetest.NewServer()
+			defer fs.Close()
+
+			fs.Enroll("user-1", frame)
+			fs.Enroll("user-1", tt.nextFrame)
+
+			for _, f := range [][]byte{frame, tt.nextFrame} {
+				fs.SetFaces(f, face.Recognition{
+					Box:        mainFace,
+					Candidates: []face.Candidate{{UserID: "user-1", Score: 1}},
+				}, face.Recognition{
+					Box: image.Rect(0, 0, 20, 20),
+				})
+			}
+
+			a := newFakeAgent("ivan", frame)
+			defer a.server.Close()
+
+			h := a.host(t, map[string]string{"ivan": "user-1"})
+
+			s := newTestService(t, "frame_reuse: {enabled: true}\n", fs, h)
+
+			s.processHost(context.Background(), h)
+
+			a.mx.Lock()
+			a.frame = tt.nextFrame
+			a.mx.Unlock()
+
+			s.processHost(context.Background(), h)
+
+			status := s.hostsStatuses[h.Name]
+			if status.Outcome != entity.OutcomeAuthorized {
+				t.Errorf("outcome = %q, want %q", status.Outcome, entity.OutcomeAuthorized)
+			}
+
+			if status.FrameReuseHits != tt.wantReuseHits {
+				t.Errorf("frame reuse hits = %d, want %d", status.FrameReuseHits, tt.wantReuseHits)
+			}
+
+			if got := fs.Requests(); got != tt.wantRequests {
+				t.Errorf("backend requests = %d, want %d", got, tt.wantRequests)
+			}
+
+			its := incidentTypes(s)
+			if len(its) != len(tt.wantIncidents) {
+				t.Fatalf("incidents = %v, want %v", its, tt.wantIncidents)
+			}
+			for i := range its {
+				if its[i] != tt.wantIncidents[i] {
+					t.Errorf("incidents = %v, want %v", its, tt.wantIncidents)
+				}
+			}
+
+			if got := len(s.NotificationDeliveries()); got != tt.wantNotifications {
+				t.Errorf("notifications = %d, want %d", got, tt.wantNotifications)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	defaultStrikesWindow = "10m"
+	defaultStrikesCount  = 1
+)
+
+type strikeKind string
+
+const (
+	strikeUnknownFace   strikeKind = "unknown_face"
+	strikeDifferentUser strikeKind = "different_user"
+
+	// strikeNotAllowedUser is a mismatch of the account owner working on the
+	// host they are not allowed to use. Different user threshold is used for it.
+	strikeNotAllowedUser strikeKind = "not_allowed_user"
+)
+
+// strikesConfig configures how many mismatches within window are required
+// before unauthorized user is logged out. Mismatches expire by window only,
+// ResetOnMatch also clears them when frame matches the active user, so only
+// mismatches in a row are counted.
+type strikesConfig struct {
+	UnknownFace   int    `yaml:"unknown_face"`
+	DifferentUser int    `yaml:"different_user"`
+	Window        string `yaml:"window"`
+	ResetOnMatch  bool   `yaml:"reset_on_match"`
+
+	window time.Duration
+}
+
+func (c *strikesConfig) setDefaults() error {
+	if c.UnknownFace == 0 {
+		c.UnknownFace = defaultStrikesCount
+	}
+
+	if c.DifferentUser == 0 {
+		c.DifferentUser = defaultStrikesCount
+	}
+
+	if c.Window == "" {
+		c.Window = defaultStrikesWindow
+	}
+
+	var err error
+
+	c.window, err = time.ParseDuration(c.Window)
+	if err != nil {
+		return fmt.Errorf("parse strikes window: %w", err)
+	}
+
+	return nil
+}
+
+func (c strikesConfig) threshold(k strikeKind) int {
+	if k == strikeDifferentUser || k == strikeNotAllowedUser {
+		return c.DifferentUser
+	}
+	return c.UnknownFace
+}
+
+// strikes keeps mismatches times of the user session by kind.
+type strikes struct {
+	user  string
+	times map[strikeKind][]time.Time
+}
+
+func (st *hostState) resetStrikes() {
+	st.strikes = strikes{}
+}
+
+func (st *hostState) strikesCount(k strikeKind) int {
+	return len(st.strikes.times[k])
+}
+
+// strike records mismatch and returns true if threshold within window is
+// reached.
+func (st *hostState) strike(activeUser string, k strikeKind, c strikesConfig) bool {
+	if st.strikes.user != activeUser || st.strikes.times == nil {
+		st.strikes = strikes{user: activeUser, times: map[strikeKind][]time.Time{}}
+	}
+
+	now := time.Now()
+
+	var ts []time.Time
+
+	for _, t := range st.strikes.times[k] {
+		if now.Sub(t) < c.window {
+			ts = append(ts, t)
+		}
+	}
+
+	ts = append(ts, now)
+
+	st.strikes.times[k] = ts
+
+	return len(ts) >= c.threshold(k)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStrike(t *testing.T) {
+	tests := []struct {
+		name string
+		c    strikesConfig
+		// prior are ages of recorded strikes of the same kind and user.
+		prior     []time.Duration
+		priorUser string
+		kind      strikeKind
+		want      bool
+		wantCount int
+	}{
+		{
+			name:      "single strike threshold",
+			c:         strikesConfig{UnknownFace: 1, DifferentUser: 3, window: time.Minute},
+			kind:      strikeUnknownFace,
+			want:      true,
+			wantCount: 1,
+		},
+		{
+			name:      "below threshold",
+			c:         strikesConfig{UnknownFace: 3, DifferentUser: 1, window: time.Minute},
+			prior:     []time.Duration{10 * time.Second},
+			kind:      strikeUnknownFace,
+			wantCount: 2,
+		},
+		{
+			name:      "threshold within window",
+			c:         strikesConfig{UnknownFace: 3, DifferentUser: 1, window: time.Minute},
+			prior:     []time.Duration{50 * time.Second, 10 * time.Second},
+			kind:      strikeUnknownFace,
+			want:      true,
+			wantCount: 3,
+		},
+		{
+			name:      "expired strikes",
+			c:         strikesConfig{UnknownFace: 3, DifferentUser: 1, window: time.Minute},
+			prior:     []time.Duration{2 * time.Minute, 70 * time.Second, 10 * time.Second},
+			kind:      strikeUnknownFace,
+			wantCount: 2,
+		},
+		{
+			name:      "other user session",
+			c:         strikesConfig{UnknownFace: 2, DifferentUser: 1, window: time.Minute},
+			prior:     []time.Duration{10 * time.Second},
+			priorUser: "petr",
+			kind:      strikeUnknownFace,
+			wantCount: 1,
+		},
+		{
+			name:      "not allowed user uses different user threshold",
+			c:         strikesConfig{UnknownFace: 1, DifferentUser: 2, window: time.Minute},
+			kind:      strikeNotAllowedUser,
+			wantCount: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := &hostState{}
+
+			if tt.prior != nil {
+				user := tt.priorUser
+				if user == "" {
+					user = "ivan"
+				}
+
+				st.strikes = strikes{user: user, times: map[strikeKind][]time.Time{}}
+
+				for _, age := range tt.prior {
+					st.strikes.times[tt.kind] = append(st.strikes.times[tt.kind], time.Now().Add(-age))
+				}
+			}
+
+			if got := st.strike("ivan", tt.kind, tt.c); got != tt.want {
+				t.Errorf("strike = %v, want %v", got, tt.want)
+			}
+
+			if got := st.strikesCount(tt.kind); got != tt.wantCount {
+				t.Errorf("strikes count = %d, want %d", got, tt.wantCount)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+// +build !windows
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+const unitsDirPath = "/etc/systemd/system"
+
+// sdNotify sends state to the systemd notification socket. It does nothing if
+// service isn't started by systemd.
+func sdNotify(state string) error {
+	socketPath := os.Getenv("NOTIFY_SOCKET")
+	if socketPath == "" {
+		return nil
+	}
+
+	// Leading @ denotes abstract socket.
+	if strings.HasPrefix(socketPath, "@") {
+		socketPath = "\x00" + socketPath[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil,
+		&net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("dial notify socket: %w", err)
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	if err != nil {
+		return fmt.Errorf("write notify socket: %w", err)
+	}
+
+	return nil
+}
+
+// unitQuote quotes command line argument for ExecStart. Specifiers and
+// variables expansion is disabled by doubling % and $.
+func unitQuote(arg string) string {
+	arg = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "%", "%%", "$", "$$").Replace(arg)
+	return `"` + arg + `"`
+}
+
+// unitEscape disables specifiers expansion in path settings, which take the
+// rest of the line as is.
+func unitEscape(path string) string {
+	return strings.Replace(path, "%", "%%", -1)
+}
+
+var unitTemplate = template.Must(template.New("unit").Funcs(template.FuncMap{
+	"quote":  unitQuote,
+	"escape": unitEscape,
+}).Parse(`[Unit]
+Description={{.Description}}
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+Type=notify
+ExecStart={{quote .ExePath}} run --config-dir {{quote .ConfigDirPath}}
+WorkingDirectory={{escape .ConfigDirPath}}
+Restart=on-failure
+RestartSec=5
+StandardOutput=journal
+StandardError=journal
+
+[Install]
+WantedBy=multi-user.target
+`))
+
+func unitFilePath(name string) string {
+	return filepath.Join(unitsDirPath, name+".service")
+}
+
+func systemctl(args ...string) error {
+	out, err := exec.Command("systemctl", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("systemctl %s: %w: %s", strings.Join(args, " "), err,
+			strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func installUnit(name, desc, exePath, configDirPath string) error {
+	filePath := unitFilePath(name)
+
+	_, err := os.Stat(filePath)
+	if err == nil {
+		return fmt.Errorf("unit %s already exists", name)
+	}
+
+	var b strings.Builder
+
+	err = unitTemplate.Execute(&b, struct {
+		Description   string
+		ExePath       string
+		ConfigDirPath string
+	}{
+		Description:   desc,
+		ExePath:       exePath,
+		ConfigDirPath: configDirPath,
+	})
+	if err != nil {
+		return fmt.Errorf("execute unit template: %w", err)
+	}
+
+	err = ioutil.WriteFile(filePath, []byte(b.String()), 0644)
+	if err != nil {
+		return fmt.Errorf("write unit file: %w", err)
+	}
+
+	err = systemctl("daemon-reload")
+	if err != nil {
+		os.Remove(filePath)
+		return err
+	}
+
+	return systemctl("enable", name)
+}
+
+func removeUnit(name string) error {
+	filePath := unitFilePath(name)
+
+	_, err := os.Stat(filePath)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("unit %s is not installed", name)
+	}
+
+	err = systemctl("disable", "--now", name)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(filePath)
+	if err != nil {
+		return fmt.Errorf("remove unit file: %w", err)
+	}
+
+	return systemctl("daemon-reload")
+}
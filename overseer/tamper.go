@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"strings"
+
+	"github.com/dimuls/oko/entity"
+	"github.com/dimuls/oko/face/tamper"
+)
+
+type cameraTamperingConfig struct {
+	Enabled       bool   `yaml:"enabled"`
+	Action        action `yaml:"action"`
+	tamper.Config `yaml:",inline"`
+}
+
+func hasReason(rs []tamper.Reason, r tamper.Reason) bool {
+	for _, rr := range rs {
+		if rr == r {
+			return true
+		}
+	}
+	return false
+}
+
+// checkCameraTampering checks camera frame and raises incident when new
+// tampering signs appear. It returns true if frame is unusable for
+// recognition.
+func (s *service) checkCameraTampering(h entity.Host, st *hostState, activeUser string, img image.Image) bool {
+	rs := st.camera.Check(tamper.Analyze(img), s.config.CameraTampering.Config)
+
+	var (
+		appeared []string
+		unusable bool
+	)
+
+	for _, r := range rs {
+		if !hasReason(st.cameraTampered, r) {
+			appeared = append(appeared, string(r))
+		}
+		if r != tamper.ReasonViewpointChanged {
+			unusable = true
+		}
+	}
+
+	if len(st.cameraTampered) > 0 && len(rs) == 0 {
+		eLog.Info(1, fmt.Sprintf("[имя_хоста=%s, имя_пользователя=%s] камера снова работает нормально", h.Name, activeUser))
+	}
+
+	st.cameraTampered = rs
+
+	if len(appeared) == 0 {
+		return unusable
+	}
+
+	s.raise(entity.Incident{
+		Type:      entity.IncidentCameraTampered,
+		HostName:  h.Name,
+		UserName:  activeUser,
+		Condition: strings.Join(appeared, ","),
+		Message: fmt.Sprintf("[имя_хоста=%s, имя_пользователя=%s, причины=%s] подозрение на вмешательство в работу камеры",
+			h.Name, activeUser, strings.Join(appeared, ", ")),
+	})
+
+	s.enforce(h, activeUser, s.config.CameraTampering.Action)
+
+	return unusable
+}
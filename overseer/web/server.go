@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/dimuls/oko/entity"
+	"github.com/dimuls/oko/overseer/notify"
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
 )
@@ -21,6 +22,14 @@ type HostProvider interface {
 	HostsStatuses() []entity.HostStatus
 }
 
+type IncidentProvider interface {
+	Incidents() []entity.Incident
+}
+
+type NotificationProvider interface {
+	NotificationDeliveries() []notify.Delivery
+}
+
 type ServerConfig struct {
 	Address  string `yaml:"address"`
 	Login    string `yaml:"login"`
@@ -33,7 +42,7 @@ type Server struct {
 	hostProvider        HostProvider
 }
 
-func NewServer(c ServerConfig, acp AgentConfigProvider, hp HostProvider) (*Server, error) {
+func NewServer(c ServerConfig, acp AgentConfigProvider, hp HostProvider, ip IncidentProvider, np NotificationProvider) (*Server, error) {
 
 	e := echo.New()
 
@@ -81,6 +90,14 @@ func NewServer(c ServerConfig, acp AgentConfigProvider, hp HostProvider) (*Serve
 		return c.JSON(http.StatusOK, hp.HostsStatuses())
 	})
 
+	p.GET("/incidents", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, ip.Incidents())
+	})
+
+	p.GET("/notifications", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, np.NotificationDeliveries())
+	})
+
 	failed := make(chan error)
 
 	go func() {
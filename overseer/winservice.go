@@ -0,0 +1,92 @@
+// +build windows
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/debug"
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+func (s *service) Execute(args []string, changeRequests <-chan svc.ChangeRequest, statusChanges chan<- svc.Status) (ssec bool, errno uint32) {
+
+	statusChanges <- svc.Status{State: svc.StartPending}
+
+	exe, err := os.Executable()
+	if err != nil {
+		errno = 1
+		return
+	}
+
+	errno = s.start(filepath.Dir(exe))
+	if errno != 0 {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		s.run(ctx)
+	}()
+
+	statusChanges <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+loop:
+	for {
+		cr := <-changeRequests
+		switch cr.Cmd {
+		case svc.Interrogate:
+			statusChanges <- cr.CurrentStatus
+		case svc.Stop, svc.Shutdown:
+			eLog.Info(1, "получен запрос остановки, останавливаю")
+			break loop
+		default:
+			eLog.Error(1, fmt.Sprintf("неизвестный управляющий запрос #%d", cr))
+		}
+	}
+
+	statusChanges <- svc.Status{State: svc.StopPending}
+
+	cancel()
+	<-done
+
+	s.stop()
+
+	statusChanges <- svc.Status{State: svc.Stopped}
+
+	return
+}
+
+func runService(name string, isDebug bool) {
+	var err error
+	if isDebug {
+		eLog = debug.New(name)
+	} else {
+		eLog, err = eventlog.Open(name)
+		if err != nil {
+			return
+		}
+	}
+	defer eLog.Close()
+
+	eLog.Info(1, fmt.Sprintf("starting %s service", name))
+	run := svc.Run
+	if isDebug {
+		run = debug.Run
+	}
+	err = run(name, &service{})
+	if err != nil {
+		eLog.Error(1, fmt.Sprintf("%s service failed: %v", name, err))
+		return
+	}
+	eLog.Info(1, fmt.Sprintf("%s service stopped", name))
+}
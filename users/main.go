@@ -1,23 +1,82 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"image/jpeg"
 	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/urfave/cli/v2"
 	"gopkg.in/yaml.v2"
 
+	"github.com/dimuls/oko/enrollment"
 	"github.com/dimuls/oko/entity"
 	"github.com/dimuls/oko/face"
 )
 
-func main() {
+var (
+	faceBackendFlag = &cli.StringFlag{
+		Name:  "face-backend",
+		Usage: "face API бэкенд, один из: " + strings.Join(face.Backends(), ", "),
+		Value: face.DefaultBackend,
+	}
+	faceParamFlag = &cli.StringSliceFlag{
+		Name:  "face-param",
+		Usage: "параметр face API бэкенда в виде ключ=значение, флаг можно указать несколько раз",
+	}
+	enrollmentDirFlag = &cli.StringFlag{
+		Name:  "enrollment-dir",
+		Usage: "путь до папки с фотографиями для дообучения Надзирателя, относительный путь отсчитывается от директории конфига Надзирателя",
+		Value: "enrollment",
+	}
+	overseerConfigDirFlag = &cli.StringFlag{
+		Name:  "overseer-config-dir",
+		Usage: "директория конфига Надзирателя, по умолчанию директория исполняемого файла",
+	}
+	recordIDFlag = &cli.StringSliceFlag{
+		Name:     "id",
+		Usage:    "идентификатор записи о фотографии для дообучения, флаг можно указать несколько раз",
+		Required: true,
+	}
+	faceCacheIndexFlag = &cli.StringFlag{
+		Name:  "face-cache-index",
+		Usage: "путь до локального индекса дескрипторов лиц Надзирателя, который нужно обновить",
+	}
+)
+
+func newFaceEnroller(c *cli.Context) (face.Enroller, error) {
+	params := map[string]interface{}{}
+
+	for _, p := range c.StringSlice(faceParamFlag.Name) {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid face param %s: expected key=value", p)
+		}
+
+		var v interface{}
 
-	app := &cli.App{
+		err := yaml.Unmarshal([]byte(kv[1]), &v)
+		if err != nil {
+			return nil, fmt.Errorf("YAML decode face param %s value: %w", kv[0], err)
+		}
+
+		params[kv[0]] = v
+	}
+
+	return face.New(face.Config{
+		Backend: c.String(faceBackendFlag.Name),
+		Cache:   face.CacheConfig{IndexFilePath: c.String(faceCacheIndexFlag.Name)},
+		Params:  params,
+	})
+}
+
+func newApp() *cli.App {
+	return &cli.App{
 		Name:  "users",
 		Usage: "управление пользователями",
 		Flags: []cli.Flag{
@@ -47,7 +106,76 @@ func main() {
 						Usage:    "путь до фотографии или папки с фотографиями, флаг можно указать несколько раз",
 						Required: true,
 					},
-					// Some face API params.
+					&cli.BoolFlag{
+						Name:  "strict",
+						Usage: "отклонять фотографии с предупреждениями о качестве",
+					},
+					faceBackendFlag,
+					faceParamFlag,
+					faceCacheIndexFlag,
+				},
+			},
+			{
+				Name:   "list_enrollment_photos",
+				Usage:  "вывести фотографии для дообучения, собранные Надзирателем",
+				Action: listEnrollmentPhotos,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "user-name",
+						Aliases: []string{"u"},
+						Usage:   "имя пользователя, по умолчанию все пользователи",
+					},
+					&cli.StringFlag{
+						Name:  "status",
+						Usage: "статус фотографий: pending, added, rejected или rolled_back, по умолчанию все",
+					},
+					enrollmentDirFlag,
+					overseerConfigDirFlag,
+				},
+			},
+			{
+				Name:   "approve_enrollment_photos",
+				Usage:  "добавить проверенные фотографии для дообучения пользователям",
+				Action: approveEnrollmentPhotos,
+				Flags: []cli.Flag{
+					recordIDFlag,
+					enrollmentDirFlag,
+					overseerConfigDirFlag,
+					faceBackendFlag,
+					faceParamFlag,
+					faceCacheIndexFlag,
+				},
+			},
+			{
+				Name:   "reject_enrollment_photos",
+				Usage:  "отклонить фотографии для дообучения",
+				Action: rejectEnrollmentPhotos,
+				Flags: []cli.Flag{
+					recordIDFlag,
+					enrollmentDirFlag,
+					overseerConfigDirFlag,
+				},
+			},
+			{
+				Name:   "rollback_enrollment_photos",
+				Usage:  "удалить у пользователя добавленные фотографии для дообучения",
+				Action: rollbackEnrollmentPhotos,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "user-name",
+						Aliases:  []string{"u"},
+						Usage:    "имя пользователя",
+						Required: true,
+					},
+					&cli.StringSliceFlag{
+						Name:  "id",
+						Usage: "идентификатор записи о фотографии, по умолчанию все добавленные фотографии пользователя",
+					},
+					enrollmentDirFlag,
+					overseerConfigDirFlag,
+					faceBackendFlag,
+					faceParamFlag,
+					faceCacheIndexFlag,
 				},
 			},
 			{
@@ -66,13 +194,17 @@ func main() {
 						Usage:    "имя пользователя, который будет удалён",
 						Required: true,
 					},
-					// Some face API params.
+					faceBackendFlag,
+					faceParamFlag,
+					faceCacheIndexFlag,
 				},
 			},
 		},
 	}
+}
 
-	err := app.Run(os.Args)
+func main() {
+	err := newApp().Run(os.Args)
 	if err != nil {
 		fmt.Println(err.Error())
 	}
@@ -119,20 +251,103 @@ func hasAllowedExtension(filePath string) bool {
 	return false
 }
 
+func printQualityReport(photoFilePath string, r face.QualityReport, strict bool) {
+	verdict := "принята"
+	if !r.OK(strict) {
+		verdict = "отклонена"
+	}
+
+	fmt.Printf("фотография %s %s: разрешение %dx%d, ориентация %d, лиц %d, размер лица %d, резкость %.1f, яркость %.1f, пересвет %.2f\n",
+		photoFilePath, verdict, r.Width, r.Height, r.Orientation, r.Faces, r.FaceSide, r.Sharpness, r.Brightness, r.Clipped)
+
+	for _, e := range r.Errors {
+		fmt.Printf("  ошибка: %s\n", e)
+	}
+
+	for _, w := range r.Warnings {
+		fmt.Printf("  предупреждение: %s\n", w)
+	}
+}
+
+// preparePhoto checks photo quality and prints report. Photo with non normal
+// EXIF orientation is rotated and saved to temporary file, path of which is
+// returned.
+func preparePhoto(photoFilePath string, strict bool) (string, bool) {
+	photo, err := ioutil.ReadFile(photoFilePath)
+	if err != nil {
+		fmt.Printf("не удалось прочитать фотографию %s: %v\n", photoFilePath, err)
+		return "", false
+	}
+
+	img, r, err := face.CheckQuality(photo, face.QualityConfig{})
+	if err != nil {
+		fmt.Printf("фотография %s отклонена: %v\n", photoFilePath, err)
+		return "", false
+	}
+
+	printQualityReport(photoFilePath, r, strict)
+
+	if !r.OK(strict) {
+		return "", false
+	}
+
+	if r.Orientation <= 1 {
+		return photoFilePath, true
+	}
+
+	f, err := ioutil.TempFile("", "oko-photo-*.jpg")
+	if err != nil {
+		fmt.Printf("не удалось создать временный файл для фотографии %s: %v\n", photoFilePath, err)
+		return "", false
+	}
+
+	err = jpeg.Encode(f, img, &jpeg.Options{Quality: 95})
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		fmt.Printf("не удалось сохранить повёрнутую фотографию %s: %v\n", photoFilePath, err)
+		return "", false
+	}
+
+	return f.Name(), true
+}
+
+// fatalFaceError reports whether face API error will repeat for every photo,
+// so there is no reason to continue.
+func fatalFaceError(err error) bool {
+	switch face.Category(err) {
+	case face.CategoryUnauthorized, face.CategoryQuotaExceeded:
+		return true
+	}
+	return false
+}
+
+func removeTempPhoto(photoFilePath, uploadFilePath string) {
+	if uploadFilePath != photoFilePath {
+		os.Remove(uploadFilePath)
+	}
+}
+
 func addUserPhotos(c *cli.Context) error {
 	hostConfigPath := c.String("host-config-path")
 	userName := c.String("user-name")
 	photosPaths := c.StringSlice("photo-path")
-	// Some face API params.
+	strict := c.Bool("strict")
 
 	h, err := loadHost(hostConfigPath)
 	if err != nil {
 		return cli.NewExitError("не удалось открыть конфиг хоста: "+err.Error(), 1)
 	}
 
-	faceAPI := face.NewAPI(face.APIConfig{
-		// Some face API params.
-	})
+	faceEnroller, err := newFaceEnroller(c)
+	if err != nil {
+		return cli.NewExitError("не удалось создать face API: "+err.Error(), 1)
+	}
+	defer face.Close(faceEnroller)
 
 	var photoFilePaths []string
 
@@ -169,12 +384,24 @@ func addUserPhotos(c *cli.Context) error {
 
 	userID, exists := h.Users[userName]
 
+	accepted := 0
+
 	for _, f := range photoFilePaths {
+		uploadFilePath, ok := preparePhoto(f, strict)
+		if !ok {
+			continue
+		}
+
+		accepted++
 
 		if !exists {
-			userID, err = faceAPI.AddUser(f)
+			userID, err = faceEnroller.AddUser(c.Context, uploadFilePath)
+			removeTempPhoto(f, uploadFilePath)
 			if err != nil {
-				fmt.Printf("не удалось добавить пользователя с фотографией %s в face API: %v\n", f, err)
+				fmt.Printf("не удалось добавить пользователя с фотографией %s в face API [категория_ошибки=%s]: %v\n", f, face.Category(err), err)
+				if fatalFaceError(err) {
+					return cli.NewExitError("face API отклонил запрос: "+err.Error(), 2)
+				}
 				continue
 			}
 
@@ -191,12 +418,18 @@ func addUserPhotos(c *cli.Context) error {
 			continue
 		}
 
-		err = faceAPI.AddUserPhoto(userID, f)
+		_, err = faceEnroller.AddUserPhoto(c.Context, userID, uploadFilePath)
+		removeTempPhoto(f, uploadFilePath)
 		if err != nil {
-			fmt.Printf("не удалось добавить фотографию %s пользователю %s: %v\n", f, userID, err)
+			fmt.Printf("не удалось добавить фотографию %s пользователю %s [категория_ошибки=%s]: %v\n", f, userID, face.Category(err), err)
+			if fatalFaceError(err) {
+				return cli.NewExitError("face API отклонил запрос: "+err.Error(), 2)
+			}
 		}
 	}
 
+	fmt.Printf("прошли проверку качества %d из %d фотографий\n", accepted, len(photoFilePaths))
+
 	return nil
 }
 
@@ -222,7 +455,6 @@ func listUsers(c *cli.Context) error {
 func removeUser(c *cli.Context) error {
 	hostConfigPath := c.String("host-config-path")
 	userName := c.String("user-name")
-	// Some face API params.
 
 	h, err := loadHost(hostConfigPath)
 	if err != nil {
@@ -238,11 +470,17 @@ func removeUser(c *cli.Context) error {
 		return nil
 	}
 
-	faceAPI := face.NewAPI(face.APIConfig{
-		// Some face API params.
-	})
+	faceEnroller, err := newFaceEnroller(c)
+	if err != nil {
+		return cli.NewExitError("не удалось создать face API: "+err.Error(), 1)
+	}
+	defer face.Close(faceEnroller)
 
-	err = faceAPI.RemoveUser(userID)
+	err = faceEnroller.RemoveUser(c.Context, userID)
+	if errors.Is(err, face.ErrUserNotFound) {
+		fmt.Printf("пользователь %s не найден в face API, удаляем из конфига хоста\n", userID)
+		err = nil
+	}
 	if err != nil {
 		return cli.NewExitError(fmt.Sprintf("не удалось удалить пользователя в face API: %v", err), 2)
 	}
@@ -258,3 +496,177 @@ func removeUser(c *cli.Context) error {
 
 	return nil
 }
+
+// openEnrollment opens enrollment store. Relative path is resolved the same
+// way as the overseer resolves it: against the overseer config directory.
+func openEnrollment(c *cli.Context) (*enrollment.Store, error) {
+	dirPath := c.String(enrollmentDirFlag.Name)
+
+	if !filepath.IsAbs(dirPath) {
+		configDirPath := c.String(overseerConfigDirFlag.Name)
+		if configDirPath == "" {
+			exe, err := os.Executable()
+			if err != nil {
+				return nil, fmt.Errorf("get executable path: %w", err)
+			}
+			configDirPath = filepath.Dir(exe)
+		}
+		dirPath = filepath.Join(configDirPath, dirPath)
+	}
+
+	return enrollment.Open(dirPath)
+}
+
+func printEnrollmentRecord(r enrollment.Record) {
+	fmt.Printf("%s: %s, хост %s, пользователь %s (%s), оценка %.2f, собрана %s",
+		r.ID, r.Status, r.HostName, r.UserName, r.UserID, r.Score, r.CreatedAt.Format(time.RFC3339))
+	if r.Error != "" {
+		fmt.Printf(", ошибка: %s", r.Error)
+	}
+	fmt.Println()
+}
+
+func listEnrollmentPhotos(c *cli.Context) error {
+	hostConfigPath := c.String("host-config-path")
+	userName := c.String("user-name")
+	status := enrollment.Status(c.String("status"))
+
+	var userID string
+
+	if userName != "" {
+		h, err := loadHost(hostConfigPath)
+		if err != nil {
+			return cli.NewExitError("не удалось открыть конфиг хоста: "+err.Error(), 1)
+		}
+
+		var exists bool
+
+		userID, exists = h.Users[userName]
+		if !exists {
+			return cli.NewExitError("пользователь "+userName+" не найден в конфиге хоста", 1)
+		}
+	}
+
+	store, err := openEnrollment(c)
+	if err != nil {
+		return cli.NewExitError("не удалось открыть папку с фотографиями для дообучения: "+err.Error(), 1)
+	}
+
+	rs, err := store.Records(userID)
+	if err != nil {
+		return cli.NewExitError("не удалось получить фотографии для дообучения: "+err.Error(), 2)
+	}
+
+	for _, r := range rs {
+		if status != "" && r.Status != status {
+			continue
+		}
+		printEnrollmentRecord(r)
+	}
+
+	return nil
+}
+
+func approveEnrollmentPhotos(c *cli.Context) error {
+	store, err := openEnrollment(c)
+	if err != nil {
+		return cli.NewExitError("не удалось открыть папку с фотографиями для дообучения: "+err.Error(), 1)
+	}
+
+	faceEnroller, err := newFaceEnroller(c)
+	if err != nil {
+		return cli.NewExitError("не удалось создать face API: "+err.Error(), 1)
+	}
+	defer face.Close(faceEnroller)
+
+	for _, id := range c.StringSlice(recordIDFlag.Name) {
+		r, err := store.Approve(c.Context, faceEnroller, id)
+		if err != nil {
+			fmt.Printf("не удалось добавить фотографию %s [категория_ошибки=%s]: %v\n", id, face.Category(err), err)
+			if fatalFaceError(err) {
+				return cli.NewExitError("face API отклонил запрос: "+err.Error(), 2)
+			}
+			continue
+		}
+		printEnrollmentRecord(r)
+	}
+
+	return nil
+}
+
+func rejectEnrollmentPhotos(c *cli.Context) error {
+	store, err := openEnrollment(c)
+	if err != nil {
+		return cli.NewExitError("не удалось открыть папку с фотографиями для дообучения: "+err.Error(), 1)
+	}
+
+	for _, id := range c.StringSlice(recordIDFlag.Name) {
+		r, err := store.Reject(id)
+		if err != nil {
+			fmt.Printf("не удалось отклонить фотографию %s: %v\n", id, err)
+			continue
+		}
+		printEnrollmentRecord(r)
+	}
+
+	return nil
+}
+
+func rollbackEnrollmentPhotos(c *cli.Context) error {
+	hostConfigPath := c.String("host-config-path")
+	userName := c.String("user-name")
+	ids := c.StringSlice("id")
+
+	h, err := loadHost(hostConfigPath)
+	if err != nil {
+		return cli.NewExitError("не удалось открыть конфиг хоста: "+err.Error(), 1)
+	}
+
+	userID, exists := h.Users[userName]
+	if !exists {
+		return cli.NewExitError("пользователь "+userName+" не найден в конфиге хоста", 1)
+	}
+
+	store, err := openEnrollment(c)
+	if err != nil {
+		return cli.NewExitError("не удалось открыть папку с фотографиями для дообучения: "+err.Error(), 1)
+	}
+
+	if len(ids) == 0 {
+		rs, err := store.Records(userID)
+		if err != nil {
+			return cli.NewExitError("не удалось получить фотографии для дообучения: "+err.Error(), 2)
+		}
+		for _, r := range rs {
+			if r.Status == enrollment.StatusAdded {
+				ids = append(ids, r.ID)
+			}
+		}
+	}
+
+	faceEnroller, err := newFaceEnroller(c)
+	if err != nil {
+		return cli.NewExitError("не удалось создать face API: "+err.Error(), 1)
+	}
+	defer face.Close(faceEnroller)
+
+	for _, id := range ids {
+		r, err := store.Get(id)
+		if err == nil && r.UserID != userID {
+			err = fmt.Errorf("photo belongs to user %s", r.UserID)
+		}
+		if err == nil {
+			r, err = store.Rollback(c.Context, faceEnroller, id)
+		}
+		if err != nil {
+			fmt.Printf("не удалось удалить фотографию %s [категория_ошибки=%s]: %v\n", id, face.Category(err), err)
+			if fatalFaceError(err) {
+				return cli.NewExitError("face API отклонил запрос: "+err.Error(), 2)
+			}
+			continue
+		}
+		printEnrollmentRecord(r)
+	}
+
+	return nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/dimuls/oko/entity"
+	"github.com/dimuls/oko/face/facetest"
+)
+
+// writePhoto writes JPEG photo with a skin colored face of the given shade
+// on gray background, or without face if shade is zero.
+func writePhoto(t *testing.T, filePath string, shade uint8) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 400, 400))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{R: 90, G: 110, B: 130, A: 255}), image.Point{}, draw.Src)
+
+	if shade != 0 {
+		for y := 100; y < 320; y++ {
+			for x := 120; x < 280; x++ {
+				dx, dy := float64(x-200)/80, float64(y-210)/110
+				if dx*dx+dy*dy > 1 {
+					continue
+				}
+				// Stripes make the face sharp enough for quality check.
+				c := color.RGBA{R: shade, G: shade - 50, B: shade - 80, A: 255}
+				if (x/4+y/4)%2 == 0 {
+					c = color.RGBA{R: shade - 20, G: shade - 70, B: shade - 95, A: 255}
+				}
+				img.Set(x, y, c)
+			}
+		}
+	}
+
+	f, err := os.Create(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = jpeg.Encode(f, img, &jpeg.Options{Quality: 95})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+func runApp(args ...string) error {
+	app := newApp()
+	app.ExitErrHandler = func(*cli.Context, error) {}
+	return app.Run(append([]string{"users"}, args...))
+}
+
+func TestAddUserPhotos(t *testing.T) {
+	tests := []struct {
+		name          string
+		users         map[string]string
+		enrolled      map[string]int
+		shades        []uint8
+		wantUserID    string
+		wantPhotos    int
+		wantFaceCalls bool
+	}{
+		{
+			name:          "new user",
+			shades:        []uint8{220, 230},
+			wantUserID:    "user-1",
+			wantPhotos:    2,
+			wantFaceCalls: true,
+		},
+		{
+			name:          "existing user",
+			users:         map[string]string{"ivan": "user-7"},
+			enrolled:      map[string]int{"user-7": 1},
+			shades:        []uint8{220},
+			wantUserID:    "user-7",
+			wantPhotos:    2,
+			wantFaceCalls: true,
+		},
+		{
+			name:   "photo without face",
+			shades: []uint8{0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dirPath, err := ioutil.TempDir("", "users-test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dirPath)
+
+			fs := facetest.NewServer()
+			defer fs.Close()
+
+			for userID, n := range tt.enrolled {
+				for i := 0; i < n; i++ {
+					fs.Enroll(userID, []byte{byte(i)})
+				}
+			}
+
+			hostConfigPath := filepath.Join(dirPath, "host.conf")
+
+			err = saveHost(entity.Host{Name: "host", Users: tt.users}, hostConfigPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			photosDirPath := filepath.Join(dirPath, "photos")
+
+			err = os.Mkdir(photosDirPath, 0775)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, shade := range tt.shades {
+				writePhoto(t, filepath.Join(photosDirPath, string(rune('a'+i))+".jpg"), shade)
+			}
+
+			err = runApp("-c", hostConfigPath, "add_user_photos", "-u", "ivan",
+				"-p", photosDirPath, "--face-param", "url="+fs.URL())
+			if err != nil {
+				t.Fatalf("run: %v", err)
+			}
+
+			h, err := loadHost(hostConfigPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := h.Users["ivan"]; got != tt.wantUserID {
+				t.Errorf("user ID = %q, want %q", got, tt.wantUserID)
+			}
+
+			if got := len(fs.Users()[tt.wantUserID]); tt.wantUserID != "" && got != tt.wantPhotos {
+				t.Errorf("enrolled photos = %d, want %d", got, tt.wantPhotos)
+			}
+
+			if got := fs.Requests() > 0; got != tt.wantFaceCalls {
+				t.Errorf("face API called = %v, want %v", got, tt.wantFaceCalls)
+			}
+		})
+	}
+}